@@ -2,9 +2,10 @@
 // SPDX-License-Identifier: Apache-2.0, MIT
 
 use std::collections::HashMap;
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
 
-use clap::{Args, Parser, Subcommand};
+use anyhow::{anyhow, Context as _};
+use clap::{Args, Parser, Subcommand, ValueEnum};
 use ethers::utils::hex::ToHexExt;
 use recall_provider::{
     fvm_shared::{address::Address, clock::ChainEpoch, econ::TokenAmount},
@@ -20,8 +21,8 @@ use recall_sdk::machine::bucket::validate_metadata;
 use recall_sdk::{
     machine::{
         bucket::{
-            AddOptions, Bucket, DeleteOptions, GetOptions, ObjectState, QueryOptions,
-            UpdateObjectMetadataOptions,
+            AddOptions, Bucket, DeleteOptions, GetOptions, ObjectRange, ObjectState, QueryOptions,
+            QuerySort, UpdateObjectMetadataOptions,
         },
         Machine,
     },
@@ -160,10 +161,14 @@ struct BucketGetArgs {
     /// Key of the object to get.
     key: String,
     /// Range of bytes to get from the object.
-    /// Format: "start-end" (inclusive).
-    /// Example: "0-99" (first 100 bytes).
+    /// Format: "start-end" (inclusive), "start-" (to the end), or "-length" (last bytes).
+    /// Example: "0-99" (first 100 bytes), "1024-", or "-512" (last 512 bytes).
+    #[arg(short, long, allow_hyphen_values = true)]
+    range: Option<ObjectRange>,
+    /// Output file to write the object to.
+    /// The object is written to stdout if not specified.
     #[arg(short, long)]
-    range: Option<String>,
+    output: Option<PathBuf>,
     /// Query block height.
     /// Possible values:
     /// "committed" (latest committed block),
@@ -190,6 +195,13 @@ struct BucketQueryArgs {
     /// The maximum number of objects to list. '0' indicates max (1000).
     #[arg(short, long, default_value_t = 0)]
     limit: u64,
+    /// Sort the objects by key name or expiry.
+    /// Sorting applies within the returned page only, not across pages.
+    #[arg(long, value_enum)]
+    sort_by: Option<SortBy>,
+    /// Reverse the order of the objects.
+    #[arg(long)]
+    reverse: bool,
     /// Query block height.
     /// Possible values:
     /// "committed" (latest committed block),
@@ -199,6 +211,23 @@ struct BucketQueryArgs {
     height: FvmQueryHeight,
 }
 
+#[derive(Debug, Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
+enum SortBy {
+    /// Lexicographically by key.
+    Name,
+    /// By expiry epoch, with ties ordered by key.
+    Expiry,
+}
+
+impl SortBy {
+    pub fn get(&self) -> QuerySort {
+        match self {
+            SortBy::Name => QuerySort::Name,
+            SortBy::Expiry => QuerySort::Expiry,
+        }
+    }
+}
+
 #[derive(Clone, Debug, Args)]
 struct BucketMetadataArgs {
     /// Wallet private key (ECDSA, secp256k1) for signing transactions.
@@ -365,18 +394,19 @@ pub async fn handle_bucket(
             )?;
 
             let machine = Bucket::attach(args.address).await?;
-            machine
-                .get(
-                    &provider,
-                    &args.key,
-                    io::stdout(),
-                    GetOptions {
-                        range: args.range.clone(),
-                        height: args.height,
-                        show_progress,
-                    },
-                )
-                .await
+            let options = GetOptions {
+                range: args.range,
+                height: args.height,
+                show_progress,
+            };
+            match &args.output {
+                Some(path) => get_to_file(&machine, &provider, &args.key, path, options).await,
+                None => {
+                    machine
+                        .get(&provider, &args.key, io::stdout(), options)
+                        .await
+                }
+            }
         }
         BucketCommands::Query(args) => {
             let provider =
@@ -391,6 +421,8 @@ pub async fn handle_bucket(
                         delimiter: args.delimiter.clone(),
                         start_key: args.start_key.clone().map(|key| key.into_bytes()),
                         limit: args.limit,
+                        sort: args.sort_by.map(|s| s.get()).unwrap_or_default(),
+                        reverse: args.reverse,
                         height: args.height,
                     },
                 )
@@ -462,6 +494,42 @@ pub async fn handle_bucket(
     }
 }
 
+/// Gets the object at `key` into a temporary file next to `path`, which is only renamed to `path`
+/// once the download is complete.
+/// This way a failed download neither leaves a partial file behind nor clobbers an existing one.
+async fn get_to_file(
+    machine: &Bucket,
+    provider: &JsonRpcProvider,
+    key: &str,
+    path: &Path,
+    options: GetOptions,
+) -> anyhow::Result<()> {
+    let file_name = path
+        .file_name()
+        .ok_or_else(|| anyhow!("invalid output path {}", path.display()))?;
+    let temp_path = path.with_file_name(format!(
+        ".{}.{}.tmp",
+        file_name.to_string_lossy(),
+        std::process::id()
+    ));
+    let file = tokio::fs::OpenOptions::new()
+        .write(true)
+        .create_new(true)
+        .open(&temp_path)
+        .await
+        .with_context(|| format!("failed to create {}", temp_path.display()))?;
+    let result = match machine.get(provider, key, file, options).await {
+        Ok(()) => tokio::fs::rename(&temp_path, path)
+            .await
+            .with_context(|| format!("failed to write {}", path.display())),
+        Err(e) => Err(e),
+    };
+    if result.is_err() {
+        let _ = tokio::fs::remove_file(&temp_path).await;
+    }
+    result
+}
+
 fn object_state_to_json(object: &ObjectState) -> Value {
     let mut val = json!({
         "hash": object.hash.to_string(),
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type testAccount struct {
+	address    string
+	privateKey string
+}
+
+// validatorTestAccounts are the first two Anvil test accounts. They are used to submit validator IPC transactions in
+// the 2-node localnet setup used for testing, so using them in tests can lead to nonce clashing issues and cause
+// unexpected failures. A single node localnet leaves them free.
+var validatorTestAccounts = []testAccount{
+	{
+		address:    "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266",
+		privateKey: "0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80",
+	},
+	{
+		address:    "0x70997970C51812dc3A010C7d01b50e0d17dc79C8",
+		privateKey: "0x59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d",
+	},
+}
+
+// defaultTestAccounts are the remaining Anvil test accounts, which are safe to use in tests.
+var defaultTestAccounts = []testAccount{
+	{
+		address:    "0x3C44CdDdB6a900fa2b585dd299e03d12FA4293BC",
+		privateKey: "0x5de4111afa1a4b94908f83103eb1f1706367c2e68ca870fc3fb9a804cdab365a",
+	},
+	{
+		address:    "0x90F79bf6EB2c4f870365E785982E1f101E93b906",
+		privateKey: "0x7c852118294e51e653712a81e05800f419141751be58f605c371e15141b007a6",
+	},
+	{
+		address:    "0x15d34AAf54267DB7D7c367839AAf71A00a2C6A65",
+		privateKey: "0x47e179ec197488593b187f80a00eb0da91f1b9d0b13f8733639f19c30a34926a",
+	},
+	{
+		address:    "0x9965507D1a55bcC2695C58ba16FB37d819B0A4dc",
+		privateKey: "0x8b3a350cf5c34c9194ca85829a2df0ec3153be0318b5e2d3348e872092edffba",
+	},
+	{
+		address:    "0x976EA74026E726554dB657fA54763abd0C3a0aa9",
+		privateKey: "0x92db14e403b83dfe3df233f83dfa3a0d7096f21ca9b0d6d6b8d88b2b4ec1564e",
+	},
+	{
+		address:    "0x14dC79964da2C08b23698B3D3cc7Ca32193d9955",
+		privateKey: "0x4bbbf85ce3377467afe5d46f804f221813b2bb87f24d81f60f1fcdbf7cbf4356",
+	},
+	{
+		address:    "0x23618e81E3f5cdF7f54C3d65f7FBc0aBf5B21E8f",
+		privateKey: "0xdbda1821b80551c9d65939329250298aa3472ba22feea921c0cf5d620ea67b97",
+	},
+	{
+		address:    "0xa0Ee7A142d267C1f36714E4a8F75612F20a79720",
+		privateKey: "0x2a871d0798f97d79848a013d4936a73bf4cc922c825d33c1cf7073dff6d409c6",
+	},
+}
+
+// testAccountPicker picks the accounts used by the tests, randomly unless an account is pinned.
+type testAccountPicker struct {
+	rand   *rand.Rand
+	pinned *testAccount
+	// Accounts to pick from at random, defaultTestAccounts when nil
+	pool []testAccount
+	// Number of distinct accounts each suite gets, 1 when zero
+	count int
+}
+
+// newTestAccountPicker returns a picker that always picks the account given by selector, or random accounts from the
+// ones that are safe to use with the localnet topology when it is empty. See findTestAccount for the selector format.
+// Each suite gets count distinct accounts, starting with the pinned one if there is one, and count can't be more than
+// the accounts there are to pick from.
+func newTestAccountPicker(
+	seed int,
+	selector string,
+	allowValidatorAccounts bool,
+	topology nodeTopology,
+	count int,
+) (*testAccountPicker, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("invalid number of test accounts %d", count)
+	}
+	picker := &testAccountPicker{count: count}
+	if topology == singleNodeTopology {
+		// No validator transactions are sent from the Anvil accounts in a single node localnet
+		allowValidatorAccounts = true
+		picker.pool = append(append([]testAccount{}, validatorTestAccounts...), defaultTestAccounts...)
+	}
+	if available := len(picker.accountPool()); picker.perSuite() > available {
+		return nil, fmt.Errorf("%d test accounts requested, but only %d are available to the tests", count, available)
+	}
+	if selector == "" || picker.perSuite() > 1 {
+		// The accounts that go with a pinned one are picked at random too
+		picker.rand = newAccountRand(seed)
+	}
+	if selector == "" {
+		return picker, nil
+	}
+
+	account, err := findTestAccount(selector, allowValidatorAccounts)
+	if err != nil {
+		return nil, err
+	}
+	infof("using pinned test account %s", account.address)
+	picker.pinned = &account
+	return picker, nil
+}
+
+// accountPool returns the accounts that are picked from at random.
+func (p *testAccountPicker) accountPool() []testAccount {
+	if p.pool == nil {
+		return defaultTestAccounts
+	}
+	return p.pool
+}
+
+// perSuite returns the number of distinct accounts each suite gets.
+func (p *testAccountPicker) perSuite() int {
+	return max(p.count, 1)
+}
+
+// privateKey returns the private key of the next account to use.
+func (p *testAccountPicker) privateKey() string {
+	if p.pinned != nil {
+		return p.pinned.privateKey
+	}
+	accounts, _ := getRandomTestAccounts(p.rand, p.accountPool(), 1)
+	return accounts[0].privateKey
+}
+
+// privateKeys returns the private keys of n distinct accounts, starting with the pinned one if there is one. It fails
+// if there are fewer than n accounts to pick from.
+func (p *testAccountPicker) privateKeys(n int) ([]string, error) {
+	var keys []string
+	pool := p.accountPool()
+	if p.pinned != nil {
+		keys = append(keys, p.pinned.privateKey)
+		pool = slices.DeleteFunc(slices.Clone(pool), func(account testAccount) bool {
+			return strings.EqualFold(account.address, p.pinned.address)
+		})
+		n--
+	}
+	accounts, err := getRandomTestAccounts(p.rand, pool, n)
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		keys = append(keys, account.privateKey)
+	}
+	return keys, nil
+}
+
+// testAccountEnv returns the environment variables that give the tests the accounts of keys: the first as
+// RECALL_PRIVATE_KEY and the others as RECALL_PRIVATE_KEY_2, RECALL_PRIVATE_KEY_3 and so on.
+func testAccountEnv(keys []string) []envVar {
+	vars := make([]envVar, len(keys))
+	for i, key := range keys {
+		vars[i] = envVar{name: "RECALL_PRIVATE_KEY", value: key}
+		if i > 0 {
+			vars[i].name += "_" + strconv.Itoa(i+1)
+		}
+	}
+	return vars
+}
+
+// findTestAccount looks up a test account by its index in defaultTestAccounts or by its address. The validator
+// accounts can only be looked up by address, and only when allowValidatorAccounts is set.
+func findTestAccount(selector string, allowValidatorAccounts bool) (testAccount, error) {
+	if index, err := strconv.Atoi(selector); err == nil {
+		if index < 0 || index >= len(defaultTestAccounts) {
+			return testAccount{}, fmt.Errorf(
+				"test account index %d out of range, expected 0 to %d", index, len(defaultTestAccounts)-1,
+			)
+		}
+		return defaultTestAccounts[index], nil
+	}
+
+	for _, account := range defaultTestAccounts {
+		if strings.EqualFold(account.address, selector) {
+			return account, nil
+		}
+	}
+	for _, account := range validatorTestAccounts {
+		if strings.EqualFold(account.address, selector) {
+			if !allowValidatorAccounts {
+				return testAccount{}, fmt.Errorf(
+					"test account %s is reserved for the localnet validators, set allowValidatorAccounts to use it",
+					selector,
+				)
+			}
+			return account, nil
+		}
+	}
+	return testAccount{}, fmt.Errorf("unknown test account %q", selector)
+}
+
+// newAccountRand returns the source of randomness for picking test accounts. When seed is zero, a random seed is
+// picked instead. The seed is logged either way, so that the accounts picked in a run can be reproduced.
+func newAccountRand(seed int) *rand.Rand {
+	if seed == 0 {
+		// Kept within 32 bits so that it can be passed back in as a function argument
+		seed = int(rand.Int31n(math.MaxInt32)) + 1
+	}
+	infof("using test account seed %d", seed)
+	return rand.New(rand.NewSource(int64(seed)))
+}
+
+// getRandomTestAccounts picks n distinct accounts at random, failing if there are fewer than n of them. The accounts
+// are drawn one at a time, so a seed picks the same first account whatever n is.
+func getRandomTestAccounts(accountRand *rand.Rand, accounts []testAccount, n int) ([]testAccount, error) {
+	if n > len(accounts) {
+		return nil, fmt.Errorf("%d distinct test accounts needed, but only %d are available", n, len(accounts))
+	}
+	picked := make([]testAccount, 0, n)
+	seen := make(map[int]bool)
+	for len(picked) < n {
+		randomIndex := accountRand.Intn(len(accounts))
+		if seen[randomIndex] {
+			continue
+		}
+		seen[randomIndex] = true
+		randomAccount := accounts[randomIndex]
+		debugf("using test account %d (%s)", randomIndex, randomAccount.address)
+		picked = append(picked, randomAccount)
+	}
+	return picked, nil
+}
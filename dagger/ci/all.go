@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// All runs the whole pipeline: Build, Lint, Audit and Doc concurrently, and Test once Build has passed. It returns a
+// report of every stage and fails if any of them did. With failFast set, the first failure cancels the stages that
+// are still running.
+func (m *Ci) All(
+	ctx context.Context,
+	// Cancel the remaining stages as soon as one fails
+	// +optional
+	failFast bool,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*AllResult, error) {
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stages := map[string]func(context.Context) (string, error){
+		"build": func(ctx context.Context) (string, error) {
+			_, err := m.Build(
+				ctx, localnetImage, registry, false, nil, false, "", nil, "", "", dockerUsername, dockerPassword, source,
+			)
+			return "", err
+		},
+		"lint": func(ctx context.Context) (string, error) {
+			return m.Lint(ctx, registry, dockerUsername, dockerPassword, source)
+		},
+		"audit": func(ctx context.Context) (string, error) {
+			return m.Audit(ctx, nil, dockerUsername, dockerPassword, source)
+		},
+		"doc": func(ctx context.Context) (string, error) {
+			_, err := m.Doc(ctx, false, dockerUsername, dockerPassword, source)
+			return "", err
+		},
+		"test": func(ctx context.Context) (string, error) {
+			result, err := m.test(ctx, testOpts{
+				localnetImage:             localnetImage,
+				registry:                  registry,
+				dockerUsername:            dockerUsername,
+				dockerPassword:            dockerPassword,
+				dumpLocalnetLogsOnFailure: true,
+				source:                    source,
+			})
+			if result == nil {
+				return "", err
+			}
+			return result.String(), err
+		},
+	}
+
+	result := &AllResult{}
+	var mu sync.Mutex
+	run := func(name string) *StageResult {
+		start := time.Now()
+		output, err := stages[name](ctx)
+		stage := &StageResult{
+			Name:     name,
+			Passed:   err == nil,
+			Duration: time.Since(start).Round(time.Millisecond).String(),
+			Output:   output,
+		}
+		switch {
+		case err != nil && ctx.Err() != nil:
+			stage.Skipped = true
+			stage.Error = "cancelled after an earlier failure"
+		case err != nil:
+			stage.Error = err.Error()
+			if failFast {
+				cancel()
+			}
+		}
+		mu.Lock()
+		result.Stages = append(result.Stages, stage)
+		mu.Unlock()
+		return stage
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"lint", "audit", "doc"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(name)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if !run("build").Passed {
+			mu.Lock()
+			result.Stages = append(result.Stages, &StageResult{Name: "test", Skipped: true, Error: "build failed"})
+			mu.Unlock()
+			return
+		}
+		run("test")
+	}()
+	wg.Wait()
+
+	var errs []error
+	for _, stage := range result.Stages {
+		if !stage.Passed && !stage.Skipped {
+			errs = append(errs, fmt.Errorf("%s failed: %s", stage.Name, stage.Error))
+		}
+	}
+	if err := parentCtx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	result.Passed = len(errs) == 0
+	return result, errors.Join(errs...)
+}
+
+// AllResult is the report of an All run.
+type AllResult struct {
+	// Whether every stage passed
+	Passed bool
+	// Stages in the order they finished
+	Stages []*StageResult
+}
+
+// StageResult is the outcome of one stage of an All run.
+type StageResult struct {
+	Name   string
+	Passed bool
+	// Set when the stage didn't run, or was cancelled, because another stage failed
+	Skipped bool
+	// How long the stage took, as a Go duration (e.g. "1m30s")
+	Duration string
+	Output   string
+	// Why the stage failed or was skipped
+	Error string
+}
+
+// String returns a summary line per stage followed by the output of each stage.
+func (r *AllResult) String() string {
+	var summary, output strings.Builder
+	for _, stage := range r.Stages {
+		status := "passed"
+		switch {
+		case stage.Skipped:
+			status = "skipped: " + stage.Error
+		case !stage.Passed:
+			status = "failed"
+		}
+		fmt.Fprintf(&summary, "%-6s %s", stage.Name, status)
+		if !stage.Skipped {
+			fmt.Fprintf(&summary, " in %s", stage.Duration)
+		}
+		summary.WriteString("\n")
+		output.WriteString(prefixLines("["+stage.Name+"] ", stage.Output))
+	}
+	return summary.String() + output.String()
+}
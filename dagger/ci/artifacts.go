@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+
+	"dagger/ci/internal/dagger"
+)
+
+// TestWithArtifacts runs Test with a JUnit report, and optionally Coverage, and bundles everything a CI job would
+// upload into one directory:
+//
+//	status              "passed" or "failed"
+//	error.txt           why the run failed, only when it did
+//	test-output.txt     the output of every phase
+//	summary.md          the markdown summary of the run
+//	junit.xml           the JUnit report of the unit and SDK tests
+//	localnet.log        the output of the localnet service
+//	coverage/lcov.info  the coverage report, only with coverage
+//
+// The directory is returned even when the tests fail, so that the artifacts of a failed run can still be exported;
+// status, rather than the call failing, says how it went. Only a run that fails before any test ran fails the call.
+func (m *Ci) TestWithArtifacts(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// Also run Coverage and include its lcov report
+	// +optional
+	coverage bool,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	result, testErr := m.test(ctx, testOpts{
+		localnetImage:             localnetImage,
+		registry:                  registry,
+		dockerUsername:            dockerUsername,
+		dockerPassword:            dockerPassword,
+		junitOutput:               true,
+		dumpLocalnetLogsOnFailure: true,
+		source:                    source,
+	})
+	if result == nil {
+		return nil, testErr
+	}
+
+	artifacts := dag.Directory().
+		WithNewFile("test-output.txt", result.String()).
+		WithNewFile("summary.md", result.Summary())
+	if result.Report != nil {
+		artifacts = artifacts.WithFile("junit.xml", result.Report)
+	}
+	if result.LocalnetLogs != nil {
+		artifacts = artifacts.WithFile("localnet.log", result.LocalnetLogs)
+	}
+	var coverageErr error
+	if coverage && testErr == nil {
+		var lcov *dagger.File
+		lcov, coverageErr = m.Coverage(ctx, localnetImage, registry, dockerUsername, dockerPassword, 0, "", source)
+		if coverageErr == nil {
+			artifacts = artifacts.WithFile("coverage/lcov.info", lcov)
+		}
+	}
+
+	status := "passed\n"
+	for _, err := range []error{testErr, coverageErr} {
+		if err != nil {
+			errorf("%v", err)
+			status = "failed\n"
+			artifacts = artifacts.WithNewFile("error.txt", err.Error()+"\n")
+		}
+	}
+	return artifacts.WithNewFile("status", status), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// advisoryIdPattern matches RustSec advisory IDs such as RUSTSEC-2023-0071.
+var advisoryIdPattern = regexp.MustCompile(`^RUSTSEC-\d{4}-\d{4}$`)
+
+// Audit checks the dependencies in Cargo.lock against the RustSec advisory database and returns the report, which
+// lists each advisory with its affected crate and severity. It fails when any vulnerability is found that isn't in
+// ignore.
+func (m *Ci) Audit(
+	ctx context.Context,
+	// RustSec advisory IDs to ignore, e.g. RUSTSEC-2023-0071
+	// +optional
+	ignore []string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	args := []string{"cargo", "audit"}
+	for _, id := range ignore {
+		if !advisoryIdPattern.MatchString(id) {
+			return "", fmt.Errorf("invalid advisory ID %q", id)
+		}
+		args = append(args, "--ignore", id)
+	}
+
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	return runReport(ctx, m.rustContainer(containerWithAuth, codeContainerOpts{cargoTools: []string{"cargo-audit"}}).
+		WithDirectory("/src", filterSource(source)).
+		WithWorkdir("/src"),
+		args,
+	)
+}
+
+// runReport runs a checking tool and returns its output. If it exits with a non-zero code, the output is included in
+// the returned error, since it's the report of what failed.
+func runReport(ctx context.Context, container *dagger.Container, args []string) (string, error) {
+	container = container.WithExec(args, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	stdout, err := container.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		stderr, _ := container.Stderr(ctx)
+		return stdout, fmt.Errorf(
+			"%s failed with exit code %d:\n%s%s", strings.Join(args, " "), exitCode, stdout, stderr,
+		)
+	}
+	return stdout, nil
+}
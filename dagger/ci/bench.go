@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Bench runs the workspace's criterion benchmarks against localnet and returns the criterion results directory.
+//
+// If baseline is set, the results are compared against that previously saved baseline and the benchmarks that
+// regressed are listed in the output. Criterion saves every run as the "base" baseline, so passing "base" compares
+// against the previous run. Benchmarks build in their own target directory, which keeps the saved baselines between
+// runs.
+func (m *Ci) Bench(
+	ctx context.Context,
+	// Name of a saved criterion baseline to compare against
+	// +optional
+	baseline string,
+	// Cache namespace to keep the caches of this run apart in, like that of Test
+	// +optional
+	cacheNamespace string,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	if cacheNamespace != "" && !cacheNamespacePattern.MatchString(cacheNamespace) {
+		return nil, fmt.Errorf("invalid cache namespace %q", cacheNamespace)
+	}
+	opts := codeContainerOpts{cacheNamespace: cacheNamespace}
+	codeContainer, localnet, err := m.setup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{},
+		opts,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return nil, err
+	}
+
+	args := []string{"cargo", "bench", "--locked", "--workspace"}
+	if baseline != "" {
+		args = append(args, "--", "--baseline", baseline)
+	}
+	benchContainer := codeContainer.
+		WithServiceBinding("localnet", localnet).
+		// Bench builds use the release profile, keep them out of the target directory used by Test
+		WithMountedCache("/bench-target", dag.CacheVolume(opts.cacheName("cargo-target-bench"))).
+		WithEnvVariable("CARGO_TARGET_DIR", "/bench-target").
+		// Benchmarks have to be measured on every run, so never reuse a cached result
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec(args)
+	stdout, err := benchContainer.Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("run benchmarks: %w", err)
+	}
+	if baseline != "" {
+		infof("%s", benchRegressions(stdout, baseline))
+	}
+
+	// The target directory is a cache volume, so the results have to be copied out of it
+	return benchContainer.
+		WithExec([]string{
+			"sh", "-c",
+			"test -d /bench-target/criterion || " +
+				"{ echo 'no criterion results found, does the workspace define any benchmarks?' >&2; exit 1; }",
+		}).
+		WithExec([]string{"cp", "-r", "/bench-target/criterion", "/criterion"}).
+		Directory("/criterion"), nil
+}
+
+// benchRegressions lists the benchmarks that criterion reported as regressed in its output. Criterion prints the
+// benchmark ID unindented, either on its own line or followed by its timings, and the verdict indented below it.
+func benchRegressions(output, baseline string) string {
+	var (
+		current   string
+		regressed []string
+	)
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			current = strings.TrimSpace(line)
+			if id, _, found := strings.Cut(current, " time:"); found {
+				current = strings.TrimSpace(id)
+			}
+		}
+		if strings.Contains(line, "Performance has regressed") && current != "" {
+			regressed = append(regressed, current)
+		}
+	}
+	if len(regressed) == 0 {
+		return fmt.Sprintf("no benchmarks regressed against baseline %s\n", baseline)
+	}
+	return fmt.Sprintf(
+		"%d benchmarks regressed against baseline %s:\n  %s\n", len(regressed), baseline, strings.Join(regressed, "\n  "),
+	)
+}
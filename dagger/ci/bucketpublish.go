@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// bucketAddressPattern matches the EVM addresses that buckets are referred to by.
+var bucketAddressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+// bucketKeyPrefixPattern matches the key prefixes PublishToBucket stores artifacts under.
+var bucketKeyPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+
+// bucketUploadScript adds every file in /artifacts to the bucket at $BUCKET_ADDRESS under $KEY_PREFIX, creating the
+// bucket first if no address is set. It then waits for a query of the prefix to list all the keys, and checks that
+// the query lists them in lexicographic order. It prints the bucket address and then the stored keys, one per line.
+const bucketUploadScript = `set -eu
+address="${BUCKET_ADDRESS:-}"
+if [ -z "$address" ]; then
+  address=$(recall bucket create | jq -r .address)
+  echo "created bucket $address" >&2
+fi
+echo "$address"
+
+: > /tmp/keys
+for file in /artifacts/*; do
+  key="$KEY_PREFIX/$(basename "$file")"
+  recall bucket add --address "$address" --key "$key" "$file" > /dev/null
+  echo "$key" >> /tmp/keys
+done
+sort /tmp/keys > /tmp/expected-keys
+
+for i in $(seq 1 30); do
+  recall bucket query --address "$address" --prefix "$KEY_PREFIX/" | jq -r '.objects[].key' > /tmp/listed-keys
+  if [ "$(sort /tmp/listed-keys)" = "$(cat /tmp/expected-keys)" ]; then
+    if ! cmp -s /tmp/listed-keys /tmp/expected-keys; then
+      echo "bucket query listed the keys out of order:" >&2
+      cat /tmp/listed-keys >&2
+      exit 1
+    fi
+    cat /tmp/listed-keys
+    exit 0
+  fi
+  sleep 2
+done
+echo "bucket query did not list all the uploaded keys, expected:" >&2
+cat /tmp/expected-keys >&2
+echo "listed:" >&2
+cat /tmp/listed-keys >&2
+exit 1
+`
+
+// PublishToBucket builds the CLI and uses it to store the release binary, and optionally the coverage report, in a
+// Recall bucket under keyPrefix. The upload is checked by querying the prefix back, which also checks that the
+// objects are listed in key order. It returns the bucket address followed by the stored keys, one per line.
+//
+// It runs against localnet unless network is set, in which case networkPrivateKey has to be that of a funded
+// account. Without bucketAddress a new bucket is created for the artifacts.
+func (m *Ci) PublishToBucket(
+	ctx context.Context,
+	// Address of the bucket to store the artifacts in, a new bucket is created when not set
+	// +optional
+	bucketAddress string,
+	// Prefix of the keys the artifacts are stored under, defaults to ci/<unix time>
+	// +optional
+	keyPrefix string,
+	// Also run the tests with coverage and store the lcov report
+	// +optional
+	includeCoverage bool,
+	// Network to store the artifacts on, localnet (the default) or testnet
+	// +optional
+	network string,
+	// Private key of a funded account on network, required when it isn't localnet
+	// +optional
+	networkPrivateKey *dagger.Secret,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if bucketAddress != "" && !bucketAddressPattern.MatchString(bucketAddress) {
+		return "", fmt.Errorf("invalid bucket address %q", bucketAddress)
+	}
+	if keyPrefix == "" {
+		keyPrefix = "ci/" + strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	keyPrefix = strings.Trim(keyPrefix, "/")
+	if !bucketKeyPrefixPattern.MatchString(keyPrefix) {
+		return "", fmt.Errorf("invalid key prefix %q", keyPrefix)
+	}
+	external, err := newExternalNetwork(ctx, network, "", "", "", networkPrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	var codeContainer *dagger.Container
+	if external != nil {
+		codeContainer, err = m.externalSetup(
+			ctx, registry, dockerUsername, dockerPassword, source, external, codeContainerOpts{},
+		)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		var localnet *dagger.Service
+		codeContainer, localnet, err = m.localnetSetup(
+			ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{}, codeContainerOpts{},
+		)
+		if err != nil {
+			return "", err
+		}
+		if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+			return "", withLocalnetLogs(ctx, codeContainer, err)
+		}
+	}
+
+	artifacts := dag.Directory().WithFile("recall", extractBinary(codeContainer, "/src/target/release/recall"))
+	if includeCoverage {
+		lcov, err := m.Coverage(ctx, localnetImage, registry, dockerUsername, dockerPassword, 0, "", source)
+		if err != nil {
+			return "", err
+		}
+		artifacts = artifacts.WithFile("lcov.info", lcov)
+	}
+
+	output, err := codeContainer.
+		WithDirectory("/artifacts", artifacts).
+		WithEnvVariable("BUCKET_ADDRESS", bucketAddress).
+		WithEnvVariable("KEY_PREFIX", keyPrefix).
+		// The artifacts have to be stored on every run, so never reuse a cached result
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", bucketUploadScript}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("publish artifacts to bucket: %w", err)
+	}
+	address, keys, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	infof("published %d artifacts to bucket %s", len(strings.Fields(keys)), address)
+	return strings.TrimSpace(output), nil
+}
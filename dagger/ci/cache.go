@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// cacheVolumes maps the caches that CleanCache can wipe to the name of their volume for opts. The target cache is the
+// one that builds of the Cargo.lock in opts use. The localnet state volumes aren't included: they are keyed by the
+// digest of the localnet image and the topology, and a run only resumes the state of its own image anyway.
+var cacheVolumes = map[string]func(opts codeContainerOpts) string{
+	"registry":      func(opts codeContainerOpts) string { return opts.cacheName("cargo-registry") },
+	"git":           func(opts codeContainerOpts) string { return opts.cacheName("cargo-git") },
+	"target":        codeContainerOpts.cargoTargetKey,
+	"coverage":      func(opts codeContainerOpts) string { return opts.cacheName("cargo-target-coverage") },
+	"bench":         func(opts codeContainerOpts) string { return opts.cacheName("cargo-target-bench") },
+	"rustup":        func(opts codeContainerOpts) string { return opts.cacheName("rustup-cache") },
+	"sccache":       func(opts codeContainerOpts) string { return opts.cacheName("sccache") },
+	"apt-archives":  func(codeContainerOpts) string { return "apt-archives" },
+	"apt-lists":     func(codeContainerOpts) string { return "apt-lists" },
+	"buildkit":      func(codeContainerOpts) string { return "buildkit-cache" },
+	"docker":        func(codeContainerOpts) string { return "docker-cache" },
+	"cross":         func(opts codeContainerOpts) string { return opts.cacheName("cross-docker") },
+	"rpc-trace":     func(codeContainerOpts) string { return "rpc-trace" },
+	"localnet-logs": func(codeContainerOpts) string { return "localnet-logs" },
+}
+
+// CleanCache empties the given cache volumes, to recover from stale or corrupted caches: registry, git, target,
+// coverage, bench, rustup, sccache, apt-archives, apt-lists, buildkit, docker, cross, rpc-trace or localnet-logs. The
+// target cache is the one for the Cargo.lock in source, with the platform, features, profile, Rust toolchain and
+// RUSTFLAGS given. Without confirm, it only lists the volumes it would empty.
+func (m *Ci) CleanCache(
+	ctx context.Context,
+	// Caches to empty
+	which []string,
+	// Actually empty the caches instead of listing them
+	// +optional
+	confirm bool,
+	// Cache namespace of the runs whose caches to empty
+	// +optional
+	cacheNamespace string,
+	// Features of the builds whose target cache to empty
+	// +optional
+	features []string,
+	// +optional
+	noDefaultFeatures bool,
+	// Cargo profile of the builds whose target cache to empty
+	// +optional
+	profile string,
+	// Rust toolchain of the builds whose target cache to empty
+	// +optional
+	rustToolchain string,
+	// RUSTFLAGS of the builds whose target cache to empty
+	// +optional
+	rustFlags string,
+	// Platform of the builds whose target cache to empty, e.g. linux/arm64, when not the default one
+	// +optional
+	platform string,
+	// Sources whose Cargo.lock keys the target cache, needed to empty it
+	// +optional
+	source *dagger.Directory,
+) (string, error) {
+	if len(which) == 0 {
+		return "", fmt.Errorf("no caches given, expected some of %s", strings.Join(cacheNames(), ", "))
+	}
+	if cacheNamespace != "" && !cacheNamespacePattern.MatchString(cacheNamespace) {
+		return "", fmt.Errorf("invalid cache namespace %q", cacheNamespace)
+	}
+	if err := validateCargoFeatures(features); err != nil {
+		return "", err
+	}
+	if profile != "" && !cargoProfilePattern.MatchString(profile) {
+		return "", fmt.Errorf("invalid cargo profile %q", profile)
+	}
+	if rustToolchain != "" && !rustToolchainPattern.MatchString(rustToolchain) {
+		return "", fmt.Errorf("invalid Rust toolchain %q", rustToolchain)
+	}
+	opts := codeContainerOpts{
+		cacheNamespace: cacheNamespace, features: features, noDefaultFeatures: noDefaultFeatures, profile: profile,
+		rustToolchain: rustToolchain, rustFlags: rustFlags, platform: dagger.Platform(platform),
+	}
+	var volumes []string
+	for _, name := range which {
+		volume, ok := cacheVolumes[name]
+		if !ok {
+			return "", fmt.Errorf("unknown cache %q, expected one of %s", name, strings.Join(cacheNames(), ", "))
+		}
+		if name == "target" {
+			if source == nil {
+				return "", fmt.Errorf("the target cache is keyed by Cargo.lock, so emptying it needs the source")
+			}
+			var err error
+			opts, err = keyTargetCache(ctx, filterSource(source), opts)
+			if err != nil {
+				return "", err
+			}
+		}
+		volumes = append(volumes, volume(opts))
+	}
+
+	if !confirm {
+		return "would empty " + strings.Join(volumes, ", ") + ", pass confirm to do so\n", nil
+	}
+	for _, volume := range volumes {
+		warnf("emptying cache volume %s", volume)
+		_, err := dag.Container().
+			From("debian:bookworm-slim").
+			// Lock the volume so that no run uses it while it is being emptied
+			WithMountedCache("/cache", dag.CacheVolume(volume), dagger.ContainerWithMountedCacheOpts{
+				Sharing: dagger.CacheSharingModeLocked,
+			}).
+			// Always empty the volume, never reuse a cached result
+			WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+			WithExec([]string{"find", "/cache", "-mindepth", "1", "-delete"}).
+			Sync(ctx)
+		if err != nil {
+			return "", fmt.Errorf("empty cache volume %s: %w", volume, err)
+		}
+	}
+	return "emptied " + strings.Join(volumes, ", ") + "\n", nil
+}
+
+// cacheNames returns the names of the caches that CleanCache can empty, sorted.
+func cacheNames() []string {
+	var names []string
+	for name := range cacheVolumes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// conventionalCommitPattern matches conventional commit subjects such as "feat(cli): add bucket get --range", with
+// the type, optional scope, optional breaking change marker and description as submatches.
+var conventionalCommitPattern = regexp.MustCompile(`^([a-z]+)(?:\(([^)]+)\))?(!)?: (.+)$`)
+
+// changelogSections are the headings that Changelog groups commits under, by conventional commit type and in order.
+// Commits of other types, or that don't follow the convention, end up under "Other changes".
+var changelogSections = []struct{ commitType, heading string }{
+	{"feat", "Features"},
+	{"fix", "Bug fixes"},
+	{"chore", "Chores"},
+}
+
+// Changelog returns markdown release notes for the commits between fromRef and toRef, grouped by conventional commit
+// type. If fromRef is empty, the tag before toRef is used, or the whole history if there is none. The sources must
+// include the .git directory.
+func (m *Ci) Changelog(
+	ctx context.Context,
+	// Ref to list the commits after, defaults to the tag before toRef
+	// +optional
+	fromRef string,
+	// Ref to list the commits up to, defaults to HEAD
+	// +optional
+	toRef string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if toRef == "" {
+		toRef = "HEAD"
+	}
+	// The refs are passed to git as arguments, where a leading dash would make them options
+	for _, ref := range []string{fromRef, toRef} {
+		if strings.HasPrefix(ref, "-") {
+			return "", fmt.Errorf("invalid git ref %q", ref)
+		}
+	}
+
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	container := m.rustContainer(containerWithAuth, codeContainerOpts{}).
+		WithDirectory("/src", source).
+		WithWorkdir("/src")
+	if fromRef == "" {
+		// describe fails when there is no earlier tag, in which case the whole history is listed
+		tag, err := container.
+			WithExec([]string{
+				"sh", "-c", `git describe --tags --abbrev=0 "$0^" 2>/dev/null || true`, toRef,
+			}).
+			Stdout(ctx)
+		if err != nil {
+			return "", fmt.Errorf("find the tag before %s: %w", toRef, err)
+		}
+		fromRef = strings.TrimSpace(tag)
+	}
+	revisions := toRef
+	if fromRef != "" {
+		revisions = fromRef + ".." + toRef
+	}
+	log, err := container.
+		WithExec([]string{"git", "log", "--no-merges", "--format=%h %s", revisions, "--"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("list commits in %s: %w", revisions, err)
+	}
+
+	title := "Changes up to " + toRef
+	if fromRef != "" {
+		title = "Changes since " + fromRef
+	}
+	return renderChangelog(title, log), nil
+}
+
+// renderChangelog formats `git log --format="%h %s"` output as markdown, with a section per conventional commit type.
+// Entries keep the order of the log, newest first.
+func renderChangelog(title, log string) string {
+	sections := map[string][]string{}
+	var breaking, other []string
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		hash, subject, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		commitType, entry, breakingChange := "", subject, false
+		if match := conventionalCommitPattern.FindStringSubmatch(subject); match != nil {
+			commitType, entry, breakingChange = match[1], match[4], match[3] != ""
+			if match[2] != "" {
+				entry = "**" + match[2] + ":** " + entry
+			}
+		}
+		entry = "- " + entry + " (" + hash + ")"
+		if breakingChange {
+			breaking = append(breaking, entry)
+		}
+		if isChangelogSection(commitType) {
+			sections[commitType] = append(sections[commitType], entry)
+		} else {
+			other = append(other, entry)
+		}
+	}
+
+	var notes strings.Builder
+	fmt.Fprintf(&notes, "## %s\n", title)
+	writeSection := func(heading string, entries []string) {
+		if len(entries) > 0 {
+			fmt.Fprintf(&notes, "\n### %s\n\n%s\n", heading, strings.Join(entries, "\n"))
+		}
+	}
+	writeSection("Breaking changes", breaking)
+	for _, section := range changelogSections {
+		writeSection(section.heading, sections[section.commitType])
+	}
+	writeSection("Other changes", other)
+	return notes.String()
+}
+
+// isChangelogSection reports whether commits of commitType have their own section in the changelog.
+func isChangelogSection(commitType string) bool {
+	for _, section := range changelogSections {
+		if section.commitType == commitType {
+			return true
+		}
+	}
+	return false
+}
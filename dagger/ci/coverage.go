@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Coverage runs the unit and SDK integration tests with coverage instrumentation and returns an lcov report. A summary
+// of the coverage is printed to stdout. If minLineCoverage is set, the run fails when the total line coverage
+// percentage is below it.
+func (m *Ci) Coverage(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	// Minimum total line coverage percentage
+	// +optional
+	minLineCoverage int,
+	// Cache namespace to keep the caches of this run apart in, like that of Test
+	// +optional
+	cacheNamespace string,
+	source *dagger.Directory,
+) (*dagger.File, error) {
+	if cacheNamespace != "" && !cacheNamespacePattern.MatchString(cacheNamespace) {
+		return nil, fmt.Errorf("invalid cache namespace %q", cacheNamespace)
+	}
+	opts := codeContainerOpts{cargoTools: []string{"cargo-llvm-cov"}, cacheNamespace: cacheNamespace}
+	codeContainer, localnet, err := m.setup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{},
+		opts,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// show-env exports the RUSTFLAGS and profile settings needed to instrument the make targets
+	showEnv := `eval "$(cargo llvm-cov show-env --export-prefix)" && `
+	report := "cargo llvm-cov report --summary-only"
+	if minLineCoverage > 0 {
+		report += fmt.Sprintf(" --fail-under-lines %d", minLineCoverage)
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return nil, err
+	}
+	coverageContainer := codeContainer.
+		WithServiceBinding("localnet", localnet).
+		// Build instrumented artifacts in their own target directory so that they don't poison the one used by Test
+		WithMountedCache("/coverage-target", dag.CacheVolume(opts.cacheName("cargo-target-coverage"))).
+		WithEnvVariable("CARGO_LLVM_COV_TARGET_DIR", "/coverage-target").
+		WithExec([]string{"mkdir", "-p", "/coverage"}).
+		WithExec([]string{
+			"sh", "-c",
+			showEnv + "cargo llvm-cov clean --workspace && " +
+				"make test run-sdk-tests && " +
+				"cargo llvm-cov report --lcov --output-path /coverage/lcov.info",
+		})
+	summary, err := coverageContainer.
+		WithExec([]string{"sh", "-c", showEnv + report}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infof("%s", summary)
+
+	return coverageContainer.File("/coverage/lcov.info"), nil
+}
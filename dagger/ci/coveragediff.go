@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// CoverageDiff generates the coverage of the sources like Coverage does and compares it against baseLcov, the lcov
+// report of the base branch. It returns a report of the lines that became covered or uncovered in each file, and
+// fails if the total line coverage dropped by more than tolerance percentage points.
+func (m *Ci) CoverageDiff(
+	ctx context.Context,
+	// lcov report to compare against, e.g. the one generated for the base branch
+	baseLcov *dagger.File,
+	// Percentage points the total line coverage may drop by, e.g. "0.5", defaults to 0
+	// +optional
+	tolerance string,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	maxDrop := 0.0
+	if tolerance != "" {
+		var err error
+		maxDrop, err = strconv.ParseFloat(tolerance, 64)
+		if err != nil || maxDrop < 0 {
+			return "", fmt.Errorf("invalid coverage tolerance %q, expected a non-negative number", tolerance)
+		}
+	}
+
+	baseContent, err := baseLcov.Contents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("read base lcov report: %w", err)
+	}
+	base, err := parseLcov(baseContent)
+	if err != nil {
+		return "", fmt.Errorf("base lcov report: %w", err)
+	}
+	lcov, err := m.Coverage(ctx, localnetImage, registry, dockerUsername, dockerPassword, 0, "", source)
+	if err != nil {
+		return "", err
+	}
+	headContent, err := lcov.Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	head, err := parseLcov(headContent)
+	if err != nil {
+		return "", err
+	}
+
+	report, drop := diffCoverage(base, head)
+	if drop > maxDrop {
+		return report, fmt.Errorf(
+			"line coverage dropped by %.2f percentage points, more than the tolerance of %.2f", drop, maxDrop,
+		)
+	}
+	return report, nil
+}
+
+// lcovCoverage maps each source file of an lcov report to the hit count of each of its instrumented lines.
+type lcovCoverage map[string]map[int]int
+
+// parseLcov reads the line coverage (SF and DA records) of an lcov report.
+func parseLcov(content string) (lcovCoverage, error) {
+	coverage := lcovCoverage{}
+	var lines map[int]int
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		record := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(record, "SF:"):
+			path := strings.TrimPrefix(record, "SF:")
+			if coverage[path] == nil {
+				coverage[path] = map[int]int{}
+			}
+			lines = coverage[path]
+		case strings.HasPrefix(record, "DA:"):
+			if lines == nil {
+				return nil, fmt.Errorf("lcov line %d: DA record outside of a source file", lineNumber)
+			}
+			fields := strings.Split(strings.TrimPrefix(record, "DA:"), ",")
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("lcov line %d: expected DA:<line>,<hits>", lineNumber)
+			}
+			line, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("lcov line %d: invalid line number: %w", lineNumber, err)
+			}
+			hits, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("lcov line %d: invalid hit count: %w", lineNumber, err)
+			}
+			lines[line] += hits
+		case record == "end_of_record":
+			lines = nil
+		}
+	}
+	return coverage, scanner.Err()
+}
+
+// percentage returns the share of instrumented lines that were hit.
+func (c lcovCoverage) percentage() float64 {
+	var total, covered int
+	for _, lines := range c {
+		for _, hits := range lines {
+			total++
+			if hits > 0 {
+				covered++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// diffCoverage reports the lines of each file that are covered in head but not in base and the other way around,
+// along with the total coverage of both. It also returns how many percentage points the total coverage dropped by,
+// which is negative when it went up.
+func diffCoverage(base, head lcovCoverage) (string, float64) {
+	paths := map[string]bool{}
+	for path := range base {
+		paths[path] = true
+	}
+	for path := range head {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var report strings.Builder
+	for _, path := range sorted {
+		added := coveredOnlyIn(head[path], base[path])
+		removed := coveredOnlyIn(base[path], head[path])
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		fmt.Fprintf(&report, "%s: +%d -%d covered lines", path, len(added), len(removed))
+		if len(removed) > 0 {
+			fmt.Fprintf(&report, " (no longer covered: %s)", joinInts(removed))
+		}
+		report.WriteString("\n")
+	}
+	basePercentage, headPercentage := base.percentage(), head.percentage()
+	fmt.Fprintf(&report, "line coverage: %.2f%% -> %.2f%% (%+.2f)\n",
+		basePercentage, headPercentage, headPercentage-basePercentage)
+	return report.String(), basePercentage - headPercentage
+}
+
+// coveredOnlyIn returns the lines, in order, that are hit in a but not in b.
+func coveredOnlyIn(a, b map[int]int) []int {
+	var lines []int
+	for line, hits := range a {
+		if hits > 0 && b[line] == 0 {
+			lines = append(lines, line)
+		}
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.Itoa(value)
+	}
+	return strings.Join(parts, ",")
+}
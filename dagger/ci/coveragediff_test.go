@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseLcov(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    lcovCoverage
+		wantErr bool
+	}{
+		{name: "empty", content: "", want: lcovCoverage{}},
+		{
+			name: "valid",
+			content: "TN:\nSF:src/lib.rs\nFN:1,main\nDA:1,3\nDA:2,0\nLF:2\nLH:1\nend_of_record\n" +
+				"SF:src/util.rs\nDA:5,1,checksum\nend_of_record\n",
+			want: lcovCoverage{"src/lib.rs": {1: 3, 2: 0}, "src/util.rs": {5: 1}},
+		},
+		{
+			name:    "repeated file adds up",
+			content: "SF:a.rs\nDA:1,1\nend_of_record\nSF:a.rs\nDA:1,2\nDA:2,0\nend_of_record\n",
+			want:    lcovCoverage{"a.rs": {1: 3, 2: 0}},
+		},
+		{name: "DA before SF", content: "DA:1,1\nSF:a.rs\n", wantErr: true},
+		{name: "DA after end_of_record", content: "SF:a.rs\nend_of_record\nDA:1,1\n", wantErr: true},
+		{name: "DA without hits", content: "SF:a.rs\nDA:1\n", wantErr: true},
+		{name: "DA with invalid line", content: "SF:a.rs\nDA:x,1\n", wantErr: true},
+		{name: "DA with invalid hits", content: "SF:a.rs\nDA:1,many\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLcov(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLcov() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLcov() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffCoverage(t *testing.T) {
+	tests := []struct {
+		name       string
+		base       lcovCoverage
+		head       lcovCoverage
+		wantReport string
+		wantDrop   float64
+	}{
+		{
+			name:       "gained lines, unchanged file omitted",
+			base:       lcovCoverage{"a.rs": {1: 1, 2: 0}, "b.rs": {1: 1}},
+			head:       lcovCoverage{"a.rs": {1: 1, 2: 3}, "b.rs": {1: 2}},
+			wantReport: "a.rs: +1 -0 covered lines\nline coverage: 66.67% -> 100.00% (+33.33)\n",
+			wantDrop:   -100.0 / 3,
+		},
+		{
+			name: "lost lines",
+			base: lcovCoverage{"a.rs": {1: 1, 2: 1, 3: 1, 4: 1}},
+			head: lcovCoverage{"a.rs": {1: 1, 2: 0, 3: 0, 4: 1}},
+			wantReport: "a.rs: +0 -2 covered lines (no longer covered: 2,3)\n" +
+				"line coverage: 100.00% -> 50.00% (-50.00)\n",
+			wantDrop: 50,
+		},
+		{
+			name:       "new file",
+			base:       lcovCoverage{},
+			head:       lcovCoverage{"new.rs": {1: 1, 2: 0}},
+			wantReport: "new.rs: +1 -0 covered lines\nline coverage: 0.00% -> 50.00% (+50.00)\n",
+			wantDrop:   -50,
+		},
+		{
+			name:       "unchanged",
+			base:       lcovCoverage{"a.rs": {1: 1, 2: 0}},
+			head:       lcovCoverage{"a.rs": {1: 5, 2: 0}},
+			wantReport: "line coverage: 50.00% -> 50.00% (+0.00)\n",
+			wantDrop:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, drop := diffCoverage(tt.base, tt.head)
+			if report != tt.wantReport {
+				t.Errorf("diffCoverage() report = %q, want %q", report, tt.wantReport)
+			}
+			if math.Abs(drop-tt.wantDrop) > 1e-9 {
+				t.Errorf("diffCoverage() drop = %v, want %v", drop, tt.wantDrop)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// crossTargetPattern matches Rust target triples.
+var crossTargetPattern = regexp.MustCompile(`^[a-z0-9_]+(-[a-z0-9_.]+){1,3}$`)
+
+// ignoredTestPattern matches the libtest line of a test that was skipped, with the test name as the submatch.
+var ignoredTestPattern = regexp.MustCompile(`(?m)^test (\S+) \.\.\. ignored`)
+
+// CrossTest runs the workspace tests for target with cross, which builds and runs them in a container for the target,
+// emulated with QEMU where it doesn't match the engine's architecture. cross needs a Docker daemon, which runs as a
+// service next to localnet; its containers share the daemon's network, so the integration tests reach localnet like
+// they do in Test. The result lists the tests that were skipped, e.g. because they can't run under emulation.
+func (m *Ci) CrossTest(
+	ctx context.Context,
+	// Rust target triple to test, e.g. aarch64-unknown-linux-gnu
+	target string,
+	// Cache namespace to keep the caches of this run apart in, like that of Test
+	// +optional
+	cacheNamespace string,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if !crossTargetPattern.MatchString(target) {
+		return "", fmt.Errorf("invalid target %q, expected a target triple such as aarch64-unknown-linux-gnu", target)
+	}
+	if cacheNamespace != "" && !cacheNamespacePattern.MatchString(cacheNamespace) {
+		return "", fmt.Errorf("invalid cache namespace %q", cacheNamespace)
+	}
+	opts := codeContainerOpts{cargoTools: []string{"cross"}, cacheNamespace: cacheNamespace}
+	codeContainer, localnet, err := m.localnetSetup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{},
+		opts,
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return "", withLocalnetLogs(ctx, codeContainer, err)
+	}
+
+	docker := dag.Container().
+		From("docker:dind").
+		WithServiceBinding("localnet", localnet).
+		WithMountedCache("/var/lib/docker", dag.CacheVolume(opts.cacheName("cross-docker"))).
+		WithExposedPort(2375).
+		AsService(dagger.ContainerAsServiceOpts{
+			Args:                     []string{"dockerd", "--host", "tcp://0.0.0.0:2375", "--tls=false"},
+			InsecureRootCapabilities: true,
+		})
+	cross := codeContainer.
+		WithFile("/usr/local/bin/docker", dag.Container().From("docker:cli").File("/usr/local/bin/docker")).
+		WithServiceBinding("docker", docker).
+		WithEnvVariable("DOCKER_HOST", "tcp://docker:2375").
+		// The daemon can't see the code container's files, so cross copies the sources into a volume
+		WithEnvVariable("CROSS_REMOTE", "1").
+		WithEnvVariable("CROSS_CONTAINER_OPTS", "--network=host").
+		WithExec(
+			[]string{"sh", "-c", "cross test --locked --workspace --target " + target + " 2>&1"},
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+		)
+	exitCode, err := cross.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	output, err := cross.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("cross test for %s failed:\n%s", target, output)
+	}
+
+	report := "tests passed for " + target + "\n"
+	if ignored := ignoredTestPattern.FindAllStringSubmatch(output, -1); len(ignored) > 0 {
+		report += fmt.Sprintf("%d tests were skipped:\n", len(ignored))
+		for _, match := range ignored {
+			report += "  " + match[1] + "\n"
+		}
+	}
+	infof("%s", strings.TrimSpace(report))
+	return report, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/ci/internal/dagger"
+)
+
+// debugManifestCmd writes build-manifest.txt to /out with what a local build needs to match for the exported
+// artifacts and incremental state to be reusable.
+const debugManifestCmd = `{
+  echo "# rustc -vV"
+  rustc -vV
+  echo
+  echo "# cargo -V"
+  cargo -V
+  echo
+  echo "# environment"
+  echo "RUSTFLAGS=${RUSTFLAGS:-}"
+  echo "CARGO_INCREMENTAL=${CARGO_INCREMENTAL:-}"
+  echo "build command: cargo build --locked"
+  echo "build status: $(cat /tmp/build-status)"
+} > /out/build-manifest.txt`
+
+// BuildDebug runs a debug build and returns its target/debug directory, including the incremental compilation
+// state, along with the Cargo.lock it was built from, the build output in build.log and a build-manifest.txt of the
+// toolchain and flags used, so that a failing CI build can be inspected or continued locally without rebuilding from
+// scratch. The directory is returned whether or not the build succeeds; the manifest records which.
+func (m *Ci) BuildDebug(
+	ctx context.Context,
+	// RUSTFLAGS for the build
+	// +optional
+	rustFlags string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+	source = filterSource(source)
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{rustFlags: rustFlags})
+	if err != nil {
+		return nil, err
+	}
+
+	// The target directory is a cache volume, so the artifacts are copied out of it to be exported
+	out, err := m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec([]string{
+			"sh", "-c",
+			"if cargo build --locked > /tmp/build.log 2>&1; then echo succeeded; else echo failed; fi > /tmp/build-status",
+		}).
+		WithExec([]string{
+			"sh", "-c",
+			"mkdir -p /out/target && cp -a target/debug /out/target/ && cp Cargo.lock /tmp/build.log /out/ && " +
+				debugManifestCmd,
+		}).
+		Directory("/out").
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("export debug build: %w", err)
+	}
+	return out, nil
+}
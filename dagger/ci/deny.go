@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Deny checks the dependencies against the license, ban, advisory and source policies of a cargo-deny config and
+// returns the report. The config defaults to the deny.toml at the root of the sources. It fails on any violation.
+func (m *Ci) Deny(
+	ctx context.Context,
+	// cargo-deny config to use instead of the deny.toml in the sources
+	// +optional
+	denyConfig *dagger.File,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	source = filterSource(source)
+	if denyConfig == nil {
+		matches, err := source.Glob(ctx, "deny.toml")
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 0 {
+			return "", fmt.Errorf("no deny.toml in the sources, pass one with denyConfig")
+		}
+		denyConfig = source.File("deny.toml")
+	}
+
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	return runReport(ctx, m.rustContainer(containerWithAuth, codeContainerOpts{cargoTools: []string{"cargo-deny"}}).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithFile("/deny.toml", denyConfig),
+		[]string{"cargo", "deny", "check", "--config", "/deny.toml", "licenses", "bans", "advisories", "sources"},
+	)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// diffCheckCmd prints the tracked files that changed, followed by their unified diff, and fails if there are any.
+const diffCheckCmd = `changed=$(git status --porcelain --untracked-files=no)
+[ -z "$changed" ] && exit 0
+echo "$changed"
+echo
+git --no-pager diff
+exit 1`
+
+// DiffCheck runs the make targets that generate files, build and doc by default, and fails if they changed any file
+// tracked by git, listing the changed files and their diff. This keeps generated files that are committed, like
+// Cargo.lock, up to date. The sources must include the .git directory.
+func (m *Ci) DiffCheck(
+	ctx context.Context,
+	// Make targets that generate files, defaults to build and doc
+	// +optional
+	targets []string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if len(targets) == 0 {
+		targets = []string{"build", "doc"}
+	}
+	for _, target := range targets {
+		if !makeTargetPattern.MatchString(target) {
+			return "", fmt.Errorf("invalid make target %q", target)
+		}
+	}
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	// Only the target directory is left out, removing anything tracked would show up in the diff
+	source = source.WithoutDirectory("target")
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{})
+	if err != nil {
+		return "", err
+	}
+
+	container, err := m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec([]string{"git", "config", "--global", "--add", "safe.directory", "/src"}).
+		WithExec(append([]string{"make"}, targets...)).
+		WithExec([]string{"sh", "-c", diffCheckCmd}, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny}).
+		Sync(ctx)
+	if err != nil {
+		return "", fmt.Errorf("make %s: %w", strings.Join(targets, " "), err)
+	}
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	diff, err := container.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("make %s changed tracked files, run it and commit the result:\n%s",
+			strings.Join(targets, " "), diff)
+	}
+	return "make " + strings.Join(targets, " ") + " changed no tracked files\n", nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Doc builds the rustdoc HTML for the workspace libraries and returns the generated doc directory. With denyWarnings
+// set, any rustdoc warning, such as a broken intra-doc link, fails the build.
+func (m *Ci) Doc(
+	ctx context.Context,
+	// Fail on rustdoc warnings
+	// +optional
+	denyWarnings bool,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	source = filterSource(source)
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{lightweight: true})
+	if err != nil {
+		return nil, err
+	}
+	container := m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src")
+	if denyWarnings {
+		container = container.WithEnvVariable("RUSTDOCFLAGS", "-D warnings")
+	}
+	// The target directory is a cache volume, so the docs have to be copied out of it
+	return container.
+		WithExec([]string{"cargo", "doc", "--locked", "--no-deps", "--workspace", "--exclude", "recall_cli"}).
+		WithExec([]string{"cp", "-r", "target/doc", "/doc"}).
+		Directory("/doc").
+		Sync(ctx)
+}
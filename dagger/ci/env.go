@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// protectedEnv are the variables of the code container that decide which network and account the tests run with.
+// Overriding them through extra env vars could leak a real key to localnet or the other way around, so that takes
+// an explicit opt-in.
+var protectedEnv = map[string]bool{
+	"RECALL_NETWORK":             true,
+	"RECALL_NETWORK_CONFIG_FILE": true,
+	networkRpcUrlEnv:             true,
+}
+
+// privateKeyEnvPattern matches the variables that hold the keys of the test accounts: RECALL_PRIVATE_KEY, and
+// RECALL_PRIVATE_KEY_2 and so on for the further accounts of testAccountCount.
+var privateKeyEnvPattern = regexp.MustCompile(`^RECALL_PRIVATE_KEY(_[0-9]+)?$`)
+
+// isProtectedEnv reports whether name is one of protectedEnv or a private key variable.
+func isProtectedEnv(name string) bool {
+	return protectedEnv[name] || privateKeyEnvPattern.MatchString(name)
+}
+
+// envVar is an environment variable set in the code container.
+type envVar struct {
+	name  string
+	value string
+}
+
+// envNamePattern matches the names of environment variables that a shell can reference.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseExtraEnv parses KEY=VALUE pairs into env vars, rejecting protected variables unless allowProtected is set. A
+// variable given more than once keeps its last value.
+func parseExtraEnv(env []string, allowProtected bool) ([]envVar, error) {
+	vars := make([]envVar, 0, len(env))
+	index := make(map[string]int)
+	for _, pair := range env {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid env var %q, expected KEY=VALUE", pair)
+		}
+		if isProtectedEnv(name) && !allowProtected {
+			return nil, fmt.Errorf("env var %s is set by the pipeline, pass allowProtectedEnv to override it", name)
+		}
+		if i, ok := index[name]; ok {
+			vars[i].value = value
+			continue
+		}
+		index[name] = len(vars)
+		vars = append(vars, envVar{name: name, value: value})
+	}
+	return vars, nil
+}
+
+// parseEnvFile parses the contents of a .env file into KEY=VALUE pairs, in the order they appear. Blank lines and
+// lines starting with # are skipped, and a leading `export` is allowed. Values can be double quoted, where \n, \t,
+// \", \$ and \\ are unescaped and the value may span lines, or single quoted, where they are taken literally. Unquoted
+// values are trimmed and end at a # preceded by whitespace. Variables aren't expanded.
+func parseEnvFile(contents string) ([]string, error) {
+	var pairs []string
+	lines := strings.Split(strings.ReplaceAll(contents, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "export "); ok {
+			line = strings.TrimSpace(rest)
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("env file line %d: expected KEY=VALUE, got %q", lineNumber, lines[i])
+		}
+		value = strings.TrimLeft(value, " \t")
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			var rest string
+			var ok bool
+			value, rest, i, ok = readDoubleQuoted(lines, i, value[1:])
+			if !ok {
+				return nil, fmt.Errorf("env file line %d: unterminated double quoted value of %s", lineNumber, name)
+			}
+			if err := checkEnvFileTrailer(rest, lineNumber, name); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("env file line %d: unterminated single quoted value of %s", lineNumber, name)
+			}
+			if err := checkEnvFileTrailer(value[end+2:], lineNumber, name); err != nil {
+				return nil, err
+			}
+			value = value[1 : end+1]
+		default:
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = value[:comment]
+			} else if comment := strings.Index(value, "\t#"); comment >= 0 {
+				value = value[:comment]
+			}
+			value = strings.TrimSpace(value)
+		}
+		pairs = append(pairs, name+"="+value)
+	}
+	return pairs, nil
+}
+
+// readDoubleQuoted unquotes the double quoted value that starts with value, after its opening quote, on line i of
+// lines and may continue on the next ones. It returns the value, what follows its closing quote and the line the
+// value ends on, or false when there is no closing quote.
+func readDoubleQuoted(lines []string, i int, value string) (string, string, int, bool) {
+	var unquoted strings.Builder
+	for {
+		for j := 0; j < len(value); j++ {
+			switch c := value[j]; {
+			case c == '"':
+				return unquoted.String(), value[j+1:], i, true
+			case c == '\\' && j+1 < len(value):
+				j++
+				switch value[j] {
+				case 'n':
+					unquoted.WriteByte('\n')
+				case 't':
+					unquoted.WriteByte('\t')
+				case '"', '\\', '$':
+					unquoted.WriteByte(value[j])
+				default:
+					unquoted.WriteByte('\\')
+					unquoted.WriteByte(value[j])
+				}
+			default:
+				unquoted.WriteByte(c)
+			}
+		}
+		if i+1 >= len(lines) {
+			return "", "", i, false
+		}
+		i++
+		unquoted.WriteByte('\n')
+		value = lines[i]
+	}
+}
+
+// checkEnvFileTrailer fails unless what follows the closing quote of a value is blank or a comment.
+func checkEnvFileTrailer(trailer string, lineNumber int, name string) error {
+	trailer = strings.TrimSpace(trailer)
+	if trailer != "" && !strings.HasPrefix(trailer, "#") {
+		return fmt.Errorf("env file line %d: unexpected %q after the quoted value of %s", lineNumber, trailer, name)
+	}
+	return nil
+}
+
+// hasEnvVar reports whether vars sets the variable name.
+func hasEnvVar(vars []envVar, name string) bool {
+	for _, envVar := range vars {
+		if envVar.name == name {
+			return true
+		}
+	}
+	return false
+}
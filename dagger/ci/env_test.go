@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseExtraEnv(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            []string
+		allowProtected bool
+		want           []envVar
+		wantErr        bool
+	}{
+		{name: "none", env: nil, want: []envVar{}},
+		{name: "key value", env: []string{"RUST_LOG=debug"}, want: []envVar{{"RUST_LOG", "debug"}}},
+		{name: "value with =", env: []string{"RUSTFLAGS=-C opt-level=3"}, want: []envVar{{"RUSTFLAGS", "-C opt-level=3"}}},
+		{name: "empty value", env: []string{"RUST_LOG="}, want: []envVar{{"RUST_LOG", ""}}},
+		{name: "keeps order", env: []string{"B=2", "A=1"}, want: []envVar{{"B", "2"}, {"A", "1"}}},
+		{name: "last value wins", env: []string{"A=1", "B=2", "A=3"}, want: []envVar{{"A", "3"}, {"B", "2"}}},
+		{name: "empty key", env: []string{"=value"}, wantErr: true},
+		{name: "missing =", env: []string{"RUST_LOG"}, wantErr: true},
+		{name: "empty pair", env: []string{""}, wantErr: true},
+		{name: "protected", env: []string{"RECALL_PRIVATE_KEY=0x01"}, wantErr: true},
+		{name: "protected numbered key", env: []string{"RECALL_PRIVATE_KEY_2=0x02"}, wantErr: true},
+		{name: "protected network", env: []string{"RECALL_NETWORK=testnet"}, wantErr: true},
+		{name: "protected config file", env: []string{"RECALL_NETWORK_CONFIG_FILE=/tmp/networks.toml"}, wantErr: true},
+		{name: "protected allowed", env: []string{"RECALL_NETWORK=testnet"}, allowProtected: true,
+			want: []envVar{{"RECALL_NETWORK", "testnet"}}},
+		{name: "not a numbered key", env: []string{"RECALL_PRIVATE_KEY_FILE=/key", "RECALL_PRIVATE_KEY_X=1"},
+			want: []envVar{{"RECALL_PRIVATE_KEY_FILE", "/key"}, {"RECALL_PRIVATE_KEY_X", "1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExtraEnv(tt.env, tt.allowProtected)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExtraEnv(%q) error = %v, wantErr %v", tt.env, err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("parseExtraEnv(%q) = %v, want %v", tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "empty", contents: "", want: nil},
+		{name: "comments and blank lines", contents: "# settings\n\nRUST_LOG=debug\n  # indented comment\n\r\nA=1\n",
+			want: []string{"RUST_LOG=debug", "A=1"}},
+		{name: "export", contents: "export RUST_LOG=debug\n", want: []string{"RUST_LOG=debug"}},
+		{name: "spacing", contents: "  RUST_LOG = debug  \n", want: []string{"RUST_LOG=debug"}},
+		{name: "empty value", contents: "RUST_LOG=\n", want: []string{"RUST_LOG="}},
+		{name: "unquoted inline comment", contents: "RUST_LOG=debug # verbose\nA=1\t# tab\n",
+			want: []string{"RUST_LOG=debug", "A=1"}},
+		{name: "unquoted # without space", contents: "URL=http://host/#frag\n", want: []string{"URL=http://host/#frag"}},
+		{name: "quoted #", contents: "A=\"x # y\" # comment\nB='x # y'\n", want: []string{"A=x # y", "B=x # y"}},
+		{name: "single quotes are literal", contents: `A='single $NOT_EXPANDED \n'`,
+			want: []string{`A=single $NOT_EXPANDED \n`}},
+		{name: "double quote escapes", contents: `A="double \n \" escapes \t \\ \$ \q"`,
+			want: []string{"A=double \n \" escapes \t \\ $ \\q"}},
+		{name: "multiline double quoted", contents: "A=\"first\nsecond\"\nB=2\n", want: []string{"A=first\nsecond", "B=2"}},
+		{name: "CRLF", contents: "A=1\r\nB=\"x\r\ny\"\r\n", want: []string{"A=1", "B=x\ny"}},
+		{name: "unterminated double quote", contents: "A=\"open\nB=2\n", wantErr: true},
+		{name: "unterminated single quote", contents: "A='open\n", wantErr: true},
+		{name: "text after double quote", contents: `A="x" y`, wantErr: true},
+		{name: "text after single quote", contents: `A='x'y`, wantErr: true},
+		{name: "invalid name", contents: "1A=1\n", wantErr: true},
+		{name: "name with dash", contents: "RUST-LOG=debug\n", wantErr: true},
+		{name: "missing =", contents: "RUST_LOG\n", wantErr: true},
+		{name: "empty name", contents: "=1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvFile(tt.contents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEnvFile(%q) error = %v, wantErr %v", tt.contents, err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("parseEnvFile(%q) = %q, want %q", tt.contents, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvFileOverriddenByEnv(t *testing.T) {
+	fileEnv, err := parseEnvFile("RUST_LOG=info\nA=1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like test(), which puts the explicit env entries after the ones of envFile
+	got, err := parseExtraEnv(append(fileEnv, "RUST_LOG=debug", "B=2"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []envVar{{"RUST_LOG", "debug"}, {"A", "1"}, {"B", "2"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseExtraEnv() = %v, want %v", got, want)
+	}
+}
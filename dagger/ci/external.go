@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// testnetConfig is the testnet entry of the SDK's default networks, see sdk/src/network.rs.
+var testnetConfig = NetworkConfig{
+	ChainId:            2481632,
+	SubnetId:           "/r314159/t410f5ooowgqbpfv747piopbp2mus5xfw7kmgpm7da2y",
+	RpcUrl:             "https://api.testnet.recall.chain.love",
+	ObjectApiUrl:       "https://objects.testnet.recall.chain.love",
+	EvmRpcUrl:          "https://evm.testnet.recall.chain.love",
+	EvmGatewayAddress:  "0x77aa40b105843728088c0132e43fc44348881da8",
+	EvmRegistryAddress: "0x74539671a1d2f1c8f200826baba665179f53a1b7",
+
+	ParentEvmRpcUrl:              "https://api.calibration.node.glif.io/rpc/v1",
+	ParentEvmGatewayAddress:      "0x1e4c292a339B037ce9b5eCA4d69dBb8dC87390Ab",
+	ParentEvmRegistryAddress:     "0x4d1EdDBb490f05e4B859Ee2288265Daa510FDeFd",
+	ParentEvmSupplySourceAddress: "0x3A4539d46C8998544E4D993D256C272F19E192bC",
+}
+
+// externalNetwork is a live network that the integration tests run against instead of localnet.
+type externalNetwork struct {
+	name   string
+	config NetworkConfig
+	// Funded account the tests send their transactions from
+	privateKey *dagger.Secret
+}
+
+// newExternalNetwork returns the network that name refers to, or nil when it is localnet. Custom networks start from
+// the testnet config and need at least rpcUrl, the other URLs default to the testnet ones. The private key is checked
+// and normalized up front, so that a malformed one fails here rather than deep in the CLI.
+func newExternalNetwork(
+	ctx context.Context,
+	name, rpcUrl, objectApiUrl, evmRpcUrl string,
+	privateKey *dagger.Secret,
+) (*externalNetwork, error) {
+	if name == "" || name == "localnet" {
+		return nil, nil
+	}
+	if name != "testnet" && rpcUrl == "" {
+		return nil, fmt.Errorf("network %q needs an RPC URL", name)
+	}
+	if privateKey == nil {
+		return nil, fmt.Errorf("network %q needs the private key of a funded account", name)
+	}
+	cfg := testnetConfig
+	for _, override := range []struct {
+		url   *string
+		value string
+	}{
+		{&cfg.RpcUrl, rpcUrl},
+		{&cfg.ObjectApiUrl, objectApiUrl},
+		{&cfg.EvmRpcUrl, evmRpcUrl},
+	} {
+		if override.value == "" {
+			continue
+		}
+		if u, err := url.Parse(override.value); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL %q for network %q", override.value, name)
+		}
+		*override.url = override.value
+	}
+	privateKey, err := validatePrivateKey(ctx, "networkPrivateKey", privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &externalNetwork{name: name, config: cfg, privateKey: privateKey}, nil
+}
+
+// externalSetup prepares the code container with the CLI built and installed, configured against network instead of
+// localnet.
+func (m *Ci) externalSetup(
+	ctx context.Context,
+	registry string,
+	dockerUsername string,
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+	network *externalNetwork,
+	opts codeContainerOpts,
+) (*dagger.Container, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", registry, dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+	source = filterSource(source)
+	opts, err = keyTargetCache(ctx, source, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.networksToml != "" {
+		warnf("using the given networks.toml instead of the generated %s config", network.name)
+	}
+	opts.network = network.name
+	opts.privateKey = network.privateKey
+	return m.codeContainer(containerWithAuth, source, renderNetworksToml(network.name, network.config), opts)
+}
+
+// checkNetwork reports whether the CometBFT RPC endpoint of the network the container runs against responds, logging
+// why not when it doesn't. The container must have curl installed and be set up by codeContainer.
+func checkNetwork(ctx context.Context, container *dagger.Container, name string) (bool, error) {
+	container = container.
+		// The network has to be checked on every run, so never reuse a cached result
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{
+			"sh", "-c",
+			`curl -sSf --max-time 10 "` + networkRpcUrlExpr + `/status" > /dev/null`,
+		}, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		return false, err
+	}
+	if exitCode != 0 {
+		stderr, _ := container.Stderr(ctx)
+		warnf("network %s is unreachable, skipping the integration tests: %s", name, strings.TrimSpace(stderr))
+		return false, nil
+	}
+	return true, nil
+}
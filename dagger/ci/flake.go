@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Flake runs an integration suite, optionally limited to the tests matching testFilter, iterations times in a row
+// against one localnet and reports how often it failed. Each iteration picks its test account from its own seed,
+// which is reported so that a failing iteration can be reproduced with Test's testAccountSeed. Failing iterations
+// don't fail the call; the report is what it is for.
+func (m *Ci) Flake(
+	ctx context.Context,
+	// Number of times to run the suite
+	iterations int,
+	// Only run tests whose name contains this string
+	// +optional
+	testFilter string,
+	// Suite to run, sdk (the default) or cli
+	// +optional
+	suite string,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*FlakeResult, error) {
+	if iterations <= 0 {
+		return nil, fmt.Errorf("invalid number of iterations %d, expected at least 1", iterations)
+	}
+	if suite == "" {
+		suite = "sdk"
+	}
+	cmd, ok := map[string]string{"sdk": "make run-sdk-tests", "cli": "make run-cli-tests"}[suite]
+	if !ok {
+		return nil, fmt.Errorf("unknown suite %q, expected sdk or cli", suite)
+	}
+
+	codeContainer, localnet, err := m.localnetSetup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{}, codeContainerOpts{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return nil, withLocalnetLogs(ctx, codeContainer, err)
+	}
+	codeContainer = codeContainer.WithEnvVariable("TEST_FILTER", testFilter)
+
+	result := &FlakeResult{Suite: suite, TestFilter: testFilter}
+	for i := 1; i <= iterations; i++ {
+		// Kept within 32 bits like the seeds picked by newAccountRand, so that it can be passed back to Test
+		seed := int(rand.Int31n(math.MaxInt32)) + 1
+		accounts, err := newTestAccountPicker(seed, "", false, twoNodeTopology, 1)
+		if err != nil {
+			return nil, err
+		}
+		iterationContainer := codeContainer.
+			WithEnvVariable("RECALL_PRIVATE_KEY", accounts.privateKey()).
+			// Iterations that pick the same account would otherwise reuse the cached result of an earlier one
+			WithEnvVariable("FLAKE_ITERATION", strconv.Itoa(i))
+		start := time.Now()
+		_, err = iterationContainer.WithExec([]string{"sh", "-c", cmd}).Sync(ctx)
+		run := &FlakeRun{
+			Iteration: i,
+			Seed:      seed,
+			Passed:    err == nil,
+			Duration:  time.Since(start).Round(time.Millisecond).String(),
+		}
+		status := "passed"
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			status = "failed"
+			run.Error = err.Error()
+			result.Failed++
+		} else {
+			result.Passed++
+		}
+		infof("%s iteration %d/%d with seed %d %s", suite, i, iterations, seed, status)
+		result.Runs = append(result.Runs, run)
+	}
+	result.FailureRate = fmt.Sprintf("%.1f%%", 100*float64(result.Failed)/float64(iterations))
+	return result, nil
+}
+
+// FlakeResult is the report of a Flake run.
+type FlakeResult struct {
+	Suite      string
+	TestFilter string
+	// Number of iterations that passed and failed
+	Passed int
+	Failed int
+	// Share of the iterations that failed, e.g. "12.5%"
+	FailureRate string
+	Runs        []*FlakeRun
+}
+
+// FlakeRun is the outcome of one iteration of a Flake run.
+type FlakeRun struct {
+	Iteration int
+	// Seed the test account was picked with, to pass as Test's testAccountSeed
+	Seed   int
+	Passed bool
+	// How long the iteration took, as a Go duration (e.g. "1m30s")
+	Duration string
+	// Why the iteration failed
+	Error string
+}
+
+// String returns the pass and fail counts and a line per iteration.
+func (r *FlakeResult) String() string {
+	var report strings.Builder
+	suite := r.Suite
+	if r.TestFilter != "" {
+		suite += " (" + r.TestFilter + ")"
+	}
+	fmt.Fprintf(&report, "%s: %d passed, %d failed, failure rate %s\n", suite, r.Passed, r.Failed, r.FailureRate)
+	for _, run := range r.Runs {
+		status := "passed"
+		if !run.Passed {
+			status = "failed"
+		}
+		fmt.Fprintf(&report, "iteration %d seed %d %s in %s\n", run.Iteration, run.Seed, status, run.Duration)
+	}
+	return report.String()
+}
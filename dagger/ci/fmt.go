@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Fmt formats the workspace with `cargo fmt` and returns the formatted sources, which can be exported over the
+// checkout to apply the changes. The unified diff of what was reformatted is printed to stdout.
+func (m *Ci) Fmt(
+	ctx context.Context,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	source = filterSource(source)
+	container := m.rustContainer(containerWithAuth, codeContainerOpts{lightweight: true}).
+		WithDirectory("/orig", source).
+		// Format outside of /src, where the target cache volume is mounted, so that the result is only the sources
+		WithDirectory("/fmt", source).
+		WithWorkdir("/fmt").
+		WithExec([]string{"cargo", "fmt", "--all"})
+	// diff exits with 1 when the files differ, only exit codes above that are errors
+	diff, err := container.
+		WithWorkdir("/").
+		WithExec([]string{"sh", "-c", "diff -ru orig fmt; [ $? -le 1 ]"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("diff formatted sources: %w", err)
+	}
+	if diff == "" {
+		infof("sources are already formatted")
+	} else {
+		infof("%s", diff)
+	}
+	return container.Directory("/fmt"), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"dagger/ci/internal/dagger"
+)
+
+// cometGenesis is the part of a CometBFT genesis file that is checked before it replaces the localnet one.
+type cometGenesis struct {
+	GenesisTime string            `json:"genesis_time"`
+	ChainId     string            `json:"chain_id"`
+	Validators  []json.RawMessage `json:"validators"`
+	AppState    json.RawMessage   `json:"app_state"`
+}
+
+// validateGenesis checks that content is a CometBFT genesis file with a chain ID, a genesis time and an app state, so
+// that a malformed override fails the run up front instead of keeping localnet from booting.
+func validateGenesis(content string) error {
+	var genesis cometGenesis
+	if err := json.Unmarshal([]byte(content), &genesis); err != nil {
+		return fmt.Errorf("invalid genesis override: %w", err)
+	}
+	switch {
+	case genesis.ChainId == "":
+		return fmt.Errorf("invalid genesis override: chain_id is not set")
+	case genesis.GenesisTime == "":
+		return fmt.Errorf("invalid genesis override: genesis_time is not set")
+	case len(genesis.AppState) == 0 || string(genesis.AppState) == "null":
+		return fmt.Errorf("invalid genesis override: app_state is not set")
+	}
+	return nil
+}
+
+// withGenesis replaces the genesis.json of every node in the localnet data directory with the one in genesis, after
+// checking that it is valid. It fails if the image has no genesis to replace.
+func withGenesis(
+	ctx context.Context,
+	localnetContainer *dagger.Container,
+	genesis *dagger.File,
+) (*dagger.Container, error) {
+	content, err := genesis.Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read genesis override: %w", err)
+	}
+	if err := validateGenesis(content); err != nil {
+		return nil, err
+	}
+	paths, err := localnetContainer.Directory(localnetDataDir).Glob(ctx, "**/genesis.json")
+	if err != nil {
+		return nil, fmt.Errorf("find the localnet genesis: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("the localnet image has no genesis.json under %s to replace", localnetDataDir)
+	}
+	for _, path := range paths {
+		debugf("replacing localnet genesis %s", path)
+		localnetContainer = localnetContainer.WithFile(localnetDataDir+"/"+path, genesis)
+	}
+	return localnetContainer, nil
+}
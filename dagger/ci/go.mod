@@ -4,6 +4,7 @@ go 1.23.6
 
 require (
 	github.com/99designs/gqlgen v0.17.66
+	github.com/BurntSushi/toml v1.4.0
 	github.com/Khan/genqlient v0.8.0
 	github.com/vektah/gqlparser/v2 v2.5.23
 	go.opentelemetry.io/otel v1.34.0
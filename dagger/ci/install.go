@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// installCheckCmd checks that `make install` put a working recall binary on the PATH, so that a broken install fails
+// the build instead of the tests that use it. It prints the installed version.
+const installCheckCmd = `{ command -v recall > /dev/null || ` +
+	`{ echo "make install did not put a recall binary on the PATH ($PATH)" >&2; exit 1; }; ` +
+	`version=$(recall --version) || { echo "recall --version failed" >&2; exit 1; }; ` +
+	`[ -n "$version" ] || { echo "recall --version printed no version" >&2; exit 1; }; ` +
+	`recall --help > /dev/null || { echo "recall --help failed" >&2; exit 1; }; ` +
+	`echo "installed $version"; }`
+
+// installPrefixCheckCmd checks that `make install` put the recall binary under CARGO_INSTALL_ROOT rather than somewhere
+// else on the PATH.
+const installPrefixCheckCmd = `{ [ -x "$CARGO_INSTALL_ROOT/bin/recall" ] || ` +
+	`{ echo "make install did not install recall under $CARGO_INSTALL_ROOT" >&2; exit 1; }; }`
+
+// defaultExportPrefix is where the CLI is installed when its install directory is exported without an installPrefix,
+// since the default cargo home also holds the toolchain.
+const defaultExportPrefix = "/opt/recall"
+
+// validateInstallPrefix checks that prefix is an absolute, clean path outside of the sources, whose target directory
+// is a cache volume that can't be exported.
+func validateInstallPrefix(prefix string) error {
+	if !path.IsAbs(prefix) || path.Clean(prefix) != prefix || prefix == "/" {
+		return fmt.Errorf("invalid install prefix %q, expected an absolute path like /opt/recall", prefix)
+	}
+	if prefix == "/src" || strings.HasPrefix(prefix, "/src/") {
+		return fmt.Errorf("invalid install prefix %q, it can't be in the sources at /src", prefix)
+	}
+	return nil
+}
+
+// InstallResult is the result of InstallCheck.
+type InstallResult struct {
+	// Version reported by the installed binary
+	Version string
+	// Install directory with the binary under bin/ and the metadata cargo keeps about it, only set with exportInstall
+	Directory *dagger.Directory
+}
+
+func (r *InstallResult) String() string {
+	return r.Version
+}
+
+// InstallCheck builds and installs the CLI the way Test does and returns the version reported by the installed binary,
+// failing if it isn't on the PATH or doesn't run. With an installPrefix, it also fails if the binary isn't under it.
+func (m *Ci) InstallCheck(
+	ctx context.Context,
+	// Directory to install the CLI under, as CARGO_INSTALL_ROOT, defaults to the cargo home or /opt/recall with
+	// exportInstall
+	// +optional
+	installPrefix string,
+	// Return the install directory, e.g. to export the installed toolset
+	// +optional
+	exportInstall bool,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*InstallResult, error) {
+	if installPrefix == "" && exportInstall {
+		installPrefix = defaultExportPrefix
+	}
+	if installPrefix != "" {
+		if err := validateInstallPrefix(installPrefix); err != nil {
+			return nil, err
+		}
+	}
+	codeContainer, _, err := m.setup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{},
+		codeContainerOpts{installPrefix: installPrefix},
+	)
+	if err != nil {
+		return nil, err
+	}
+	version, err := codeContainer.WithExec([]string{"recall", "--version"}).Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("install check: %w", err)
+	}
+	result := &InstallResult{Version: strings.TrimSpace(version)}
+	if exportInstall {
+		result.Directory, err = codeContainer.Directory(installPrefix).Sync(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", installPrefix, err)
+		}
+	}
+	return result, nil
+}
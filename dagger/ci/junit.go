@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"dagger/ci/internal/dagger"
+)
+
+// nextestJunitPath is where cargo-nextest writes the JUnit report for the ci profile in .config/nextest.toml.
+const nextestJunitPath = "/src/target/nextest/ci/junit.xml"
+
+// junitTestSuites is the root element of a JUnit XML report, keeping the suites themselves as raw XML.
+type junitTestSuites struct {
+	XMLName  xml.Name `xml:"testsuites"`
+	Tests    int      `xml:"tests,attr"`
+	Failures int      `xml:"failures,attr"`
+	Errors   int      `xml:"errors,attr"`
+	Suites   []byte   `xml:",innerxml"`
+}
+
+// copyJunitReport returns a shell command that copies the nextest JUnit report to /reports/<name>.xml. The target
+// directory is a cache volume shared between containers, so the report has to be copied out of it before another run
+// overwrites it.
+func copyJunitReport(name string) string {
+	return "mkdir -p /reports && cp " + nextestJunitPath + " /reports/" + name + ".xml"
+}
+
+// junitReport returns the report copied by copyJunitReport in container.
+func junitReport(container *dagger.Container, name string) *dagger.File {
+	return container.File("/reports/" + name + ".xml")
+}
+
+// mergeJunitReports merges JUnit reports into a single report.
+func mergeJunitReports(ctx context.Context, reports ...*dagger.File) (*dagger.File, error) {
+	merged := junitTestSuites{}
+	for _, report := range reports {
+		content, err := report.Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("read JUnit report: %w", err)
+		}
+		var suites junitTestSuites
+		if err := xml.Unmarshal([]byte(content), &suites); err != nil {
+			return nil, fmt.Errorf("parse JUnit report: %w", err)
+		}
+		merged.Tests += suites.Tests
+		merged.Failures += suites.Failures
+		merged.Errors += suites.Errors
+		merged.Suites = append(merged.Suites, suites.Suites...)
+	}
+
+	content := xml.Header +
+		`<testsuites name="recall" tests="` + strconv.Itoa(merged.Tests) +
+		`" failures="` + strconv.Itoa(merged.Failures) +
+		`" errors="` + strconv.Itoa(merged.Errors) + `">` +
+		string(merged.Suites) +
+		"</testsuites>\n"
+	return dag.Directory().WithNewFile("junit.xml", content).File("junit.xml"), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// localnetMemoryPattern matches the memory limits that cgroup v2 accepts in memory.max: bytes, optionally with a K, M
+// or G suffix.
+var localnetMemoryPattern = regexp.MustCompile(`^[1-9][0-9]*[KMG]?$`)
+
+// cpuPeriod is the cgroup CPU period in microseconds that the CPU limit is a quota of.
+const cpuPeriod = 100000
+
+// localnetLimits are the resource limits of the localnet service. The zero value leaves it unconstrained.
+type localnetLimits struct {
+	// memory.max of the service, e.g. 4G
+	memory string
+	// CPUs the service may use, e.g. 1.5
+	cpus float64
+}
+
+// parseLocalnetLimits parses a memory limit such as "4G" and a CPU limit such as "1.5", either of which may be empty
+// for no limit.
+func parseLocalnetLimits(memory, cpus string) (localnetLimits, error) {
+	var limits localnetLimits
+	if memory != "" {
+		if !localnetMemoryPattern.MatchString(memory) {
+			return limits, fmt.Errorf("invalid localnet memory limit %q, expected bytes with an optional K, M or G suffix",
+				memory)
+		}
+		limits.memory = memory
+	}
+	if cpus != "" {
+		n, err := strconv.ParseFloat(cpus, 64)
+		if err != nil || n <= 0 {
+			return limits, fmt.Errorf("invalid localnet CPU limit %q, expected a positive number of CPUs", cpus)
+		}
+		limits.cpus = n
+	}
+	return limits, nil
+}
+
+// script returns the shell commands that apply the limits to the cgroup of the localnet service, which its processes
+// and the containers that localnet starts run under, or nothing when there are none. The memory events of the cgroup
+// are kept next to the log file passed as $0 while the service runs, so that withLocalnetLimits can tell whether
+// localnet was OOM-killed. The service runs with root capabilities, which lets it write its own cgroup limits.
+func (l localnetLimits) script() string {
+	var script strings.Builder
+	if l.memory != "" {
+		fmt.Fprintf(&script, "echo %s > /sys/fs/cgroup/memory.max\n", l.memory)
+		script.WriteString(`(while :; do cp /sys/fs/cgroup/memory.events "$0.memory-events"; sleep 2; done) &` + "\n")
+	}
+	if l.cpus > 0 {
+		fmt.Fprintf(&script, "echo '%d %d' > /sys/fs/cgroup/cpu.max\n", int(l.cpus*cpuPeriod), cpuPeriod)
+	}
+	return script.String()
+}
+
+// withLocalnetLimits adds to err when localnet ran into its memory limit, since an OOM-killed localnet otherwise only
+// shows up as connection errors in the tests. The container must have been set up by setup, like for
+// withLocalnetLogs. err is returned unchanged when the limit wasn't hit or the memory events can't be read.
+func withLocalnetLimits(ctx context.Context, container *dagger.Container, limits localnetLimits, err error) error {
+	if limits.memory == "" {
+		return err
+	}
+	events, readErr := container.
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", `cat "$LOCALNET_LOG_FILE.memory-events"`}).
+		Stdout(ctx)
+	if readErr != nil {
+		debugf("could not read the localnet memory events: %v", readErr)
+		return err
+	}
+	for _, line := range strings.Split(events, "\n") {
+		name, value, _ := strings.Cut(line, " ")
+		if name != "oom_kill" {
+			continue
+		}
+		if kills, _ := strconv.Atoi(value); kills > 0 {
+			return fmt.Errorf("%w\n\nlocalnet ran out of memory: %d processes were OOM-killed at its %s memory limit, "+
+				"raise localnetMemoryLimit", err, kills, limits.memory)
+		}
+	}
+	return err
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// localnetLogDir is where the localnet service writes its output, on a cache volume that the code container also
+// mounts so that the logs can be read back when the tests fail.
+const localnetLogDir = "/var/log/localnet"
+
+// localnetLogTailLines is how many lines of the localnet logs are attached to a failure.
+const localnetLogTailLines = 200
+
+var localnetLogCache = dag.CacheVolume("localnet-logs")
+
+// localnetDataDir is where the localnet image keeps its configuration and chain state.
+const localnetDataDir = "/workdir/localnet-data"
+
+// localnetOpts configures the localnet service started by setup.
+type localnetOpts struct {
+	ports    localnetPorts
+	topology nodeTopology
+	// Refuse localnet images that aren't pinned by digest
+	requireDigest bool
+	// Keep the chain state in a cache volume to resume from on the next run instead of starting from genesis
+	reuseState bool
+	// Genesis to boot the chain from instead of the image's
+	genesis *dagger.File
+	limits  localnetLimits
+	// Container to run instead of pulling the localnet image, e.g. one built by another module
+	container *dagger.Container
+	// Directory with a Dockerfile to build the localnet image from instead of pulling it
+	buildContext *dagger.Directory
+}
+
+// localImage returns the localnet container given in localnet, or the one built from its build context. Neither can
+// be combined with a localnetImage to pull, and since they don't come from a registry, they can't be pinned by digest
+// or have their state reused, which is keyed by the image digest.
+func localImage(localnetImage string, localnet localnetOpts) (*dagger.Container, error) {
+	switch {
+	case localnet.container != nil && localnet.buildContext != nil:
+		return nil, fmt.Errorf("only one of a localnet container and a localnet build context can be given")
+	case localnetImage != "":
+		return nil, fmt.Errorf("localnetImage can't be combined with a localnet container or build context")
+	case localnet.requireDigest:
+		return nil, fmt.Errorf("a localnet container or build context isn't pinned by digest, drop requireDigest")
+	case localnet.reuseState:
+		return nil, fmt.Errorf("the localnet state is keyed by image digest, so it can't be reused with a localnet " +
+			"container or build context")
+	case localnet.container != nil:
+		infof("using the given localnet container instead of pulling an image")
+		return localnet.container, nil
+	}
+	infof("building the localnet image from the given build context")
+	return localnet.buildContext.DockerBuild(), nil
+}
+
+// nodeTopology is the number of validator nodes localnet runs.
+type nodeTopology string
+
+const (
+	// twoNodeTopology is the default setup of the localnet image, in which the first two Anvil accounts are used by
+	// the validators.
+	twoNodeTopology nodeTopology = "two-node"
+	// singleNodeTopology runs a single validator, leaving all the Anvil accounts to the tests.
+	singleNodeTopology nodeTopology = "single"
+)
+
+// localnetNodesEnv is the environment variable of the localnet image that sets how many validator nodes it starts.
+// It is only set for the single node topology, the two-node one is what the image starts without it, and
+// checkLocalnetValidators fails the run when the image started more validators anyway.
+const localnetNodesEnv = "LOCALNET_NODES"
+
+// parseLocalnetTopology parses a topology name, falling back to twoNodeTopology when it is empty.
+func parseLocalnetTopology(topology string) (nodeTopology, error) {
+	switch nodeTopology(topology) {
+	case "", twoNodeTopology:
+		return twoNodeTopology, nil
+	case singleNodeTopology:
+		return singleNodeTopology, nil
+	}
+	return "", fmt.Errorf("invalid localnet topology %q, expected %s or %s", topology, singleNodeTopology, twoNodeTopology)
+}
+
+// defaultLocalnetTimeout is how long to wait for localnet to produce blocks when no timeout is given.
+const defaultLocalnetTimeout = 120 * time.Second
+
+// parseLocalnetTimeout parses a Go duration string such as "90s" or "5m", falling back to defaultLocalnetTimeout when
+// it is empty.
+func parseLocalnetTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return defaultLocalnetTimeout, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid localnet timeout %q: %w", timeout, err)
+	}
+	return d, nil
+}
+
+// localnet returns the localnet service of localnetImage, along with its network config as reached through the
+// "localnet" service binding and the file the service writes its logs to. The service isn't started.
+func (m *Ci) localnet(
+	ctx context.Context,
+	containerWithAuth *dagger.Container,
+	registry string,
+	localnetImage string,
+	localnet localnetOpts,
+) (*dagger.Service, NetworkConfig, string, error) {
+	localnetContainer, err := m.getLocalnetImage(ctx, containerWithAuth, registry, localnetImage, localnet)
+	if err != nil {
+		return nil, NetworkConfig{}, "", err
+	}
+	if localnet.topology == singleNodeTopology {
+		localnetContainer = localnetContainer.WithEnvVariable(localnetNodesEnv, "1")
+	}
+
+	networksTomlContent, err := localnetContainer.
+		File(localnetDataDir + "/networks.toml").
+		Contents(ctx)
+	if err != nil {
+		return nil, NetworkConfig{}, "", err
+	}
+	config, err := parseNetworksToml(networksTomlContent)
+	if err != nil {
+		return nil, NetworkConfig{}, "", err
+	}
+	// The localnet endpoints are reached through the "localnet" service binding instead of localhost
+	config, err = config.withHost("localnet", localnet.ports)
+	if err != nil {
+		return nil, NetworkConfig{}, "", err
+	}
+
+	if localnet.genesis != nil {
+		localnetContainer, err = withGenesis(ctx, localnetContainer, localnet.genesis)
+		if err != nil {
+			return nil, NetworkConfig{}, "", err
+		}
+	}
+	if localnet.reuseState {
+		localnetContainer, err = withLocalnetState(ctx, localnetContainer, localnet.topology)
+		if err != nil {
+			return nil, NetworkConfig{}, "", err
+		}
+	}
+	logFile := localnetLogFile()
+	service, err := m.localnetService(ctx, localnetContainer, config.ports(), logFile, localnet.limits)
+	if err != nil {
+		return nil, NetworkConfig{}, "", err
+	}
+	return service, config, logFile, nil
+}
+
+// Localnet starts a Recall localnet and returns it once it produces blocks, for other modules to run their tests
+// against. Bind it into a container as "localnet", since its networks.toml points at that host; the exposed ports are
+// the CometBFT RPC, EVM RPC, parent EVM RPC and object API ones of the image.
+func (m *Ci) Localnet(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// Number of validator nodes: two-node (the default) or single
+	// +optional
+	localnetTopology string,
+	// How long to wait for localnet to produce blocks, as a Go duration (e.g. "5m"), defaults to 120s
+	// +optional
+	localnetTimeout string,
+	// CometBFT genesis to boot the chain from instead of the image's, e.g. with other validators or funded accounts
+	// +optional
+	genesisOverride *dagger.File,
+	// Localnet container to run instead of pulling localnetImage, e.g. one built by another module
+	// +optional
+	localnetContainer *dagger.Container,
+	// Directory with a Dockerfile to build the localnet image from instead of pulling localnetImage
+	// +optional
+	localnetBuildContext *dagger.Directory,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+) (*dagger.Service, error) {
+	topology, err := parseLocalnetTopology(localnetTopology)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseLocalnetTimeout(localnetTimeout)
+	if err != nil {
+		return nil, err
+	}
+	containerWithAuth, err := m.getContainerWithAuth("", registry, dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+	service, config, _, err := m.localnet(
+		ctx, containerWithAuth, registry, localnetImage, localnetOpts{
+			topology: topology, genesis: genesisOverride,
+			container: localnetContainer, buildContext: localnetBuildContext,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	service, err = service.Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("start localnet: %w", err)
+	}
+	probe := containerWithAuth.
+		From("debian:bookworm-slim").
+		WithExec([]string{"apt-get", "update"}).
+		WithExec([]string{"apt-get", "install", "-y", "--no-install-recommends", "ca-certificates", "curl", "jq"}).
+		WithEnvVariable(networkRpcUrlEnv, config.RpcUrl)
+	if err := m.waitForLocalnet(ctx, probe, service, timeout, defaultMinHeightDelta); err != nil {
+		stopLocalnet(ctx, service)
+		return nil, err
+	}
+	if topology == singleNodeTopology && genesisOverride == nil {
+		if err := m.checkLocalnetValidators(ctx, probe, service, 1); err != nil {
+			stopLocalnet(ctx, service)
+			return nil, err
+		}
+	}
+	return service, nil
+}
+
+// defaultMinHeightDelta is how many blocks localnet has to produce while it is polled before it is considered ready.
+const defaultMinHeightDelta = 1
+
+// parseMinHeightDelta returns the minimum block height delta of the readiness check, falling back to
+// defaultMinHeightDelta when it is zero.
+func parseMinHeightDelta(delta int) (int, error) {
+	switch {
+	case delta < 0:
+		return 0, fmt.Errorf("invalid localnet height delta %d, expected a positive number of blocks", delta)
+	case delta == 0:
+		return defaultMinHeightDelta, nil
+	}
+	return delta, nil
+}
+
+// waitForLocalnet binds svc into container and polls the CometBFT status endpoint until the chain is producing blocks,
+// failing if that doesn't happen within timeout. The height is recorded once it is non-zero and localnet is only ready
+// once it has grown by at least minHeightDelta since, so that a chain that answers but is stuck isn't mistaken for a
+// ready one. The container must have curl and jq installed, and NETWORK_RPC_URL set like codeContainer does.
+func (m *Ci) waitForLocalnet(
+	ctx context.Context,
+	container *dagger.Container,
+	svc *dagger.Service,
+	timeout time.Duration,
+	minHeightDelta int,
+) error {
+	seconds := strconv.Itoa(int(timeout.Seconds()))
+	delta := strconv.Itoa(minHeightDelta)
+	stdout, err := container.
+		WithServiceBinding("localnet", svc).
+		// The chain has to be checked on every run, so never reuse a cached result
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{
+			"sh", "-c",
+			"deadline=$(($(date +%s) + " + seconds + "))\n" +
+				"start=\n" +
+				"while :; do\n" +
+				"  if height=$(curl -sf \"" + networkRpcUrlExpr + "/status\" |\n" +
+				"    jq -r .result.sync_info.latest_block_height) &&\n" +
+				"    [ \"${height:-0}\" -gt 0 ] 2>/dev/null; then\n" +
+				"    [ -n \"$start\" ] || start=$height\n" +
+				"    last=$height\n" +
+				"    [ \"$((last - start))\" -ge " + delta + " ] && break\n" +
+				"  fi\n" +
+				"  if [ \"$(date +%s)\" -ge \"$deadline\" ]; then\n" +
+				"    if [ -n \"$start\" ]; then\n" +
+				"      echo \"localnet is stuck: its block height went from $start to $last, not up by " + delta +
+				", within " + seconds + "s\" >&2\n" +
+				"    else\n" +
+				"      echo \"localnet did not produce blocks within " + seconds + "s\" >&2\n" +
+				"    fi\n" +
+				"    exit 1\n" +
+				"  fi\n" +
+				"  sleep 2\n" +
+				"done\n" +
+				"echo \"localnet ready at block height $last, up from $start\"",
+		}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("wait for localnet: %w", err)
+	}
+	infof("%s", stdout)
+	return nil
+}
+
+// checkLocalnetValidators binds svc into container and fails unless the CometBFT validator set of localnet has want
+// validators. The single node topology relies on the image honouring localnetNodesEnv, and an image that ignores it
+// still starts two validators, whose accounts the tests would then pick, so the count is checked once the chain is
+// up. The container must have curl and jq installed, and NETWORK_RPC_URL set like codeContainer does.
+func (m *Ci) checkLocalnetValidators(
+	ctx context.Context,
+	container *dagger.Container,
+	svc *dagger.Service,
+	want int,
+) error {
+	_, err := container.
+		WithServiceBinding("localnet", svc).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{
+			"sh", "-c",
+			"total=$(curl -sf \"" + networkRpcUrlExpr + "/validators\" | jq -r .result.total)\n" +
+				"[ -n \"$total\" ] ||\n" +
+				"  { echo \"could not get the localnet validators from $" + networkRpcUrlEnv + "\" >&2; exit 1; }\n" +
+				"if [ \"$total\" != " + strconv.Itoa(want) + " ]; then\n" +
+				"  echo \"localnet runs $total validators instead of " + strconv.Itoa(want) + ", the image doesn't " +
+				"support " + localnetNodesEnv + ", use the two-node topology or a genesisOverride with one validator\" >&2\n" +
+				"  exit 1\n" +
+				"fi",
+		}).
+		Sync(ctx)
+	if err != nil {
+		return fmt.Errorf("check localnet validators: %w", err)
+	}
+	return nil
+}
+
+// withLocalnetState mounts a cache volume over the data directory of localnetContainer, seeded with the data the
+// image ships with, so that a later run resumes the chain where the last one left it instead of booting it from
+// genesis. The volume is keyed by the digest of the image and the topology, since state written by another image or
+// number of validators can't be resumed. It is mounted privately, so concurrent runs each get their own copy instead
+// of writing to the same chain state.
+//
+// This relies on the localnet image resuming from existing state in its data directory rather than always
+// regenerating it.
+func withLocalnetState(
+	ctx context.Context,
+	localnetContainer *dagger.Container,
+	topology nodeTopology,
+) (*dagger.Container, error) {
+	ref, err := localnetContainer.ImageRef(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("resolve localnet image digest: %w", err)
+	}
+	key := "localnet-state-" + lockfileHash(ref)
+	if topology != "" {
+		key += "-" + string(topology)
+	}
+	debugf("reusing localnet state from cache volume %s", key)
+	return localnetContainer.WithMountedCache(localnetDataDir, dag.CacheVolume(key), dagger.ContainerWithMountedCacheOpts{
+		Source:  localnetContainer.Directory(localnetDataDir),
+		Sharing: dagger.CacheSharingModePrivate,
+	}), nil
+}
+
+// stopLocalnet stops the localnet service after a failed run, so that it doesn't keep running on the engine until it
+// times out. It stops the service with a context of its own, since a cancelled ctx may be why the run failed.
+func stopLocalnet(ctx context.Context, svc *dagger.Service) {
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	if _, err := svc.Stop(stopCtx, dagger.ServiceStopOpts{Kill: true}); err != nil {
+		warnf("could not stop the localnet service: %v", err)
+		return
+	}
+	infof("stopped the localnet service")
+}
+
+// localnetLogFile returns a path under localnetLogDir that is unique to this run, so that concurrent runs sharing the
+// log cache volume don't mix their output.
+func localnetLogFile() string {
+	return localnetLogDir + "/" + strconv.FormatInt(time.Now().UnixNano(), 36) + ".log"
+}
+
+// localnetLogs returns the log file of the localnet service, copied out of the log cache volume when it is read so that
+// it has everything the service wrote up to then. The container must have been set up by setup, like for
+// withLocalnetLogs.
+func localnetLogs(container *dagger.Container) *dagger.File {
+	return container.
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", `cp "$LOCALNET_LOG_FILE" /tmp/localnet.log`}).
+		File("/tmp/localnet.log")
+}
+
+// withLocalnetLogs appends the tail of the localnet service logs to err. The container must have been set up by
+// setup, which points LOCALNET_LOG_FILE at the service's log file. If the logs can't be read err is returned with a
+// note saying why, since the original failure is what matters.
+func withLocalnetLogs(ctx context.Context, container *dagger.Container, err error) error {
+	logs, readErr := container.
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{
+			"sh", "-c", "tail -n " + strconv.Itoa(localnetLogTailLines) + " \"$LOCALNET_LOG_FILE\"",
+		}).
+		Stdout(ctx)
+	if readErr != nil {
+		return fmt.Errorf("%w\n\n(could not read localnet logs: %v)", err, readErr)
+	}
+	return fmt.Errorf(
+		"%w\n\nlast %d lines of localnet logs:\n%s", err, localnetLogTailLines, strings.TrimRight(logs, "\n"),
+	)
+}
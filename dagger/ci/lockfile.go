@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+	"github.com/BurntSushi/toml"
+)
+
+// lockfilePackages parses a Cargo.lock into the versions of each package it locks. A package can be locked at several
+// versions at once.
+func lockfilePackages(lockfile string) (map[string][]string, error) {
+	var lock struct {
+		Package []struct {
+			Name    string `toml:"name"`
+			Version string `toml:"version"`
+		} `toml:"package"`
+	}
+	if _, err := toml.Decode(lockfile, &lock); err != nil {
+		return nil, fmt.Errorf("parse Cargo.lock: %w", err)
+	}
+	packages := make(map[string][]string)
+	for _, pkg := range lock.Package {
+		packages[pkg.Name] = append(packages[pkg.Name], pkg.Version)
+	}
+	for _, versions := range packages {
+		slices.Sort(versions)
+	}
+	return packages, nil
+}
+
+// lockfileChanges lists the packages whose locked versions differ between the old and new Cargo.lock, sorted by name,
+// e.g. "serde 1.0.200 -> 1.0.203", "+ tokio-util 0.7.11" or "- base64 0.21.7".
+func lockfileChanges(old, new map[string][]string) []string {
+	var changes []string
+	for name, newVersions := range new {
+		oldVersions, ok := old[name]
+		switch {
+		case !ok:
+			changes = append(changes, "+ "+name+" "+strings.Join(newVersions, ", "))
+		case !slices.Equal(oldVersions, newVersions):
+			changes = append(changes,
+				name+" "+strings.Join(oldVersions, ", ")+" -> "+strings.Join(newVersions, ", "))
+		}
+	}
+	for name, oldVersions := range old {
+		if _, ok := new[name]; !ok {
+			changes = append(changes, "- "+name+" "+strings.Join(oldVersions, ", "))
+		}
+	}
+	slices.SortFunc(changes, func(a, b string) int {
+		return strings.Compare(strings.TrimLeft(a, "+- "), strings.TrimLeft(b, "+- "))
+	})
+	return changes
+}
+
+// LockfileCheck fails if Cargo.lock is missing or out of date with the manifests, listing the packages that cargo
+// would add, remove or change in it. This catches dependency changes that were committed without the lockfile.
+func (m *Ci) LockfileCheck(
+	ctx context.Context,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	source = filterSource(source)
+	lockfile, err := source.File("Cargo.lock").Contents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("read Cargo.lock, it has to be committed: %w", err)
+	}
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+
+	container := m.rustContainer(containerWithAuth, codeContainerOpts{}).
+		WithDirectory("/src", source).
+		WithWorkdir("/src")
+	locked, err := container.
+		WithExec([]string{"sh", "-c", "cargo metadata --locked --format-version 1 > /dev/null"},
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny}).
+		Sync(ctx)
+	if err != nil {
+		return "", err
+	}
+	exitCode, err := locked.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exitCode == 0 {
+		return "Cargo.lock is up to date\n", nil
+	}
+
+	// Without --locked, cargo updates the lockfile as little as the manifests need, like a build would
+	updated, err := container.
+		WithExec([]string{"sh", "-c", "cargo metadata --format-version 1 > /dev/null"}).
+		File("/src/Cargo.lock").
+		Contents(ctx)
+	if err != nil {
+		stderr, _ := locked.Stderr(ctx)
+		return "", fmt.Errorf("resolve the dependencies: %w\n%s", err, stderr)
+	}
+	oldPackages, err := lockfilePackages(lockfile)
+	if err != nil {
+		return "", err
+	}
+	newPackages, err := lockfilePackages(updated)
+	if err != nil {
+		return "", err
+	}
+	changes := lockfileChanges(oldPackages, newPackages)
+	if len(changes) == 0 {
+		// Only the metadata of the lockfile differs, e.g. its version or checksums
+		changes = []string{"no package versions, only the lockfile metadata"}
+	}
+	return "", fmt.Errorf("Cargo.lock is out of date with the manifests, run cargo update -w and commit it. "+
+		"It would change:\n%s", strings.Join(changes, "\n"))
+}
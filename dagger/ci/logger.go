@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// logLevel orders the pipeline's log messages by importance. Only messages at or above the level set with
+// setLogLevel are printed.
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// currentLogLevel is read by concurrent pipeline stages, so it is only accessed atomically.
+var currentLogLevel atomic.Int32
+
+func init() {
+	currentLogLevel.Store(int32(levelInfo))
+}
+
+// parseLogLevel parses one of the level names, falling back to info when it is empty.
+func parseLogLevel(level string) (logLevel, error) {
+	if level == "" {
+		return levelInfo, nil
+	}
+	for i, name := range logLevelNames {
+		if strings.EqualFold(level, name) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q, expected one of %s", level, strings.Join(logLevelNames, ", "))
+}
+
+func setLogLevel(level logLevel) {
+	currentLogLevel.Store(int32(level))
+}
+
+// logf prints a message at level, prefixed with the level name. Messages must never include secret values, such as
+// passwords or private keys, whatever their level.
+func logf(level logLevel, format string, args ...any) {
+	if int32(level) < currentLogLevel.Load() {
+		return
+	}
+	log.Printf(strings.ToUpper(logLevelNames[level])+" "+format, args...)
+}
+
+func debugf(format string, args ...any) { logf(levelDebug, format, args...) }
+func infof(format string, args ...any)  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...any)  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...any) { logf(levelError, format, args...) }
@@ -2,131 +2,1682 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
-	"math/rand"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"dagger/ci/internal/dagger"
 )
 
 type Ci struct{}
 
+func init() {
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Ltime | log.Lmsgprefix)
+}
+
+// defaultRegistry is where images are pulled from when no registry is given.
+const defaultRegistry = "docker.io"
+
 // Create build cache volumes
 var buildkitCache = dag.CacheVolume("buildkit-cache")
 var dockerCache = dag.CacheVolume("docker-cache")
 
+// Test lints the code, runs the unit, SDK and CLI integration tests against localnet, and builds the docs.
+//
+// If testFilter is set, only tests whose name contains it are run. For the unit and SDK tests it is passed to
+// `cargo test` as the test name filter, so it matches against the full test path (e.g. `bucket::can_add` or just
+// `can_add`). For the CLI tests it is matched against the test script file names in tests/cli.
 func (m *Ci) Test(
 	ctx context.Context,
 	// +optional
 	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// Fail unless localnetImage is pinned by digest (e.g. textile/recall-localnet@sha256:...), for reproducible runs
+	// +optional
+	requireDigest bool,
 	// +optional
 	dockerUsername string,
 	// +optional
 	dockerPassword *dagger.Secret,
+	// Base image for the code container, defaults to rust:slim-bookworm
+	// +optional
+	rustImage string,
+	// Rust toolchain to install and use instead of the one in rust-toolchain.toml
+	// +optional
+	rustToolchain string,
+	// apt packages to install in the code container in addition to the default ones, e.g. for crates with native
+	// dependencies
+	// +optional
+	extraPackages []string,
+	// Only run tests whose name contains this string
+	// +optional
+	testFilter string,
+	// Make target to run for the lint phase instead of lint
+	// +optional
+	lintTarget string,
+	// Make target to run for the unit test phase instead of test, or test-nextest with junitOutput
+	// +optional
+	unitTarget string,
+	// Make target to run for the SDK test phase instead of run-sdk-tests, or run-sdk-tests-nextest with junitOutput
+	// +optional
+	sdkTarget string,
+	// Make target to run for the CLI test phase instead of run-cli-tests
+	// +optional
+	cliTarget string,
+	// Make target to run for the doc phase instead of doc
+	// +optional
+	docTarget string,
+	// Phases to skip: lint, unit, sdk, cli or doc. They are reported as skipped in the result
+	// +optional
+	skip []string,
+	// Run every phase after the build even when an earlier one fails, and fail with all their errors at the end
+	// +optional
+	keepGoing bool,
+	// Number of times to retry an integration suite that fails because localnet couldn't be reached
+	// +optional
+	integrationRetries int,
+	// How long to wait for localnet to produce blocks before running the integration tests, as a Go duration
+	// (e.g. "90s"), defaults to 120s
+	// +optional
+	localnetTimeout string,
+	// Number of blocks localnet has to produce while the readiness check polls it, so that a stuck chain isn't
+	// considered ready, defaults to 1
+	// +optional
+	localnetHeightDelta int,
+	// Override the localnet CometBFT RPC port
+	// +optional
+	rpcPort int,
+	// Override the localnet EVM RPC port
+	// +optional
+	evmRpcPort int,
+	// Override the localnet object API port
+	// +optional
+	objectApiPort int,
+	// Override the localnet parent chain EVM RPC port
+	// +optional
+	parentEvmRpcPort int,
+	// Number of localnet validators, "two-node" (the default) or "single". A single node localnet leaves the first
+	// two Anvil accounts, otherwise used by the validators, free for the tests, and fails the run if the image still
+	// starts two.
+	// +optional
+	localnetTopology string,
+	// Resume the localnet chain from the state a previous run with the same image and topology left, instead of
+	// starting from genesis
+	// +optional
+	reuseLocalnetState bool,
+	// CometBFT genesis to boot localnet from instead of the image's, e.g. with other validators or funded accounts
+	// +optional
+	genesisOverride *dagger.File,
+	// Memory limit of the localnet service, e.g. 4G, unlimited by default. A run that fails after localnet was
+	// OOM-killed says so.
+	// +optional
+	localnetMemoryLimit string,
+	// Number of CPUs the localnet service may use, e.g. 1.5, unlimited by default
+	// +optional
+	localnetCpuLimit string,
+	// Localnet container to run instead of pulling localnetImage, e.g. one built by another module
+	// +optional
+	localnetContainer *dagger.Container,
+	// Directory with a Dockerfile to build the localnet image from instead of pulling localnetImage, for testing
+	// changes to the image
+	// +optional
+	localnetBuildContext *dagger.Directory,
+	// Fail instead of skipping the integration tests when localnet doesn't produce blocks within localnetTimeout
+	// +optional
+	requireLocalnet bool,
+	// Network to run the integration tests against: localnet (the default), which is started for the run, testnet, or
+	// a custom name with networkRpcUrl set. Custom networks use the testnet config for anything not overridden.
+	// +optional
+	testTargetNetwork string,
+	// CometBFT RPC URL of testTargetNetwork
+	// +optional
+	networkRpcUrl string,
+	// Object API URL of testTargetNetwork
+	// +optional
+	networkObjectApiUrl string,
+	// EVM RPC URL of testTargetNetwork
+	// +optional
+	networkEvmRpcUrl string,
+	// Private key of a funded account on testTargetNetwork, required when it isn't localnet
+	// +optional
+	networkPrivateKey *dagger.Secret,
+	// Run the unit and SDK tests with cargo-nextest and return a JUnit XML report of them
+	// +optional
+	junitOutput bool,
+	// Seed for picking the test accounts, to reproduce a run that used the same seed. A random seed is used and
+	// logged when not set.
+	// +optional
+	testAccountSeed int,
+	// Test account to use instead of random ones, either an index into the list of test accounts or an address
+	// +optional
+	testAccount string,
+	// Number of distinct accounts each test suite gets, as RECALL_PRIVATE_KEY, RECALL_PRIVATE_KEY_2 and so on, for
+	// tests with several parties such as transfers. Defaults to 1; the first is testAccount when it is set.
+	// +optional
+	testAccountCount int,
+	// Allow testAccount to be one of the accounts reserved for the localnet validators
+	// +optional
+	allowValidatorAccounts bool,
+	// Cache compilation results with sccache and report its cache hit rate
+	// +optional
+	useSccache bool,
+	// Suffix for the names of the cargo, rustup and sccache cache volumes, e.g. a branch name. Runs in the same
+	// namespace share their caches, which is faster, but concurrent runs in one namespace contend on the cargo locks
+	// of the target cache.
+	// +optional
+	cacheNamespace string,
+	// Cargo features to enable for the build and tests, optionally qualified by package, e.g. recall_sdk/foo
+	// +optional
+	features []string,
+	// Disable the default features for the build and tests
+	// +optional
+	noDefaultFeatures bool,
+	// Cargo profile to build and install the CLI with: release (the default), dev or a custom profile from
+	// Cargo.toml. The tests use cargo's test profile either way.
+	// +optional
+	profile string,
+	// RUSTFLAGS for the build and tests. With "-C target-cpu=native" the target cache is kept per set of CPU features,
+	// since the cached artifacts can't run on CPUs that lack them.
+	// +optional
+	rustFlags string,
+	// Maximum time each phase (build, lint, unit, sdk, cli, doc) may take, as a Go duration (e.g. "20m"). Phases
+	// aren't limited when not set.
+	// +optional
+	phaseTimeout string,
+	// Don't let cargo access the network, building from the registry cache or vendor instead
+	// +optional
+	offline bool,
+	// Dependencies vendored by the vendor function, used instead of crates.io and git sources
+	// +optional
+	vendor *dagger.Directory,
+	// Large test fixtures to mount at /src/test-fixtures, which the tests find through RECALL_TEST_FIXTURES
+	// +optional
+	fixtures *dagger.Directory,
+	// Token for fetching private git dependencies over HTTPS, e.g. a GitHub token with read access to them
+	// +optional
+	gitToken *dagger.Secret,
+	// Registry index to fetch crates from instead of crates.io, e.g. sparse+https://mirror.example.com/index/
+	// +optional
+	cratesMirror string,
+	// Proxy for the HTTP and HTTPS requests of the build, e.g. http://proxy.example.com:3128
+	// +optional
+	httpsProxy string,
+	// networks.toml to use instead of the one generated for localnet or testTargetNetwork. It has to configure the
+	// network the tests run against.
+	// +optional
+	networksToml *dagger.File,
+	// Extra environment variables for the build and tests as KEY=VALUE, e.g. RUST_LOG=debug
+	// +optional
+	env []string,
+	// .env file of extra environment variables for the build and tests, one KEY=VALUE per line. Variables in env
+	// override the ones in the file.
+	// +optional
+	envFile *dagger.File,
+	// Allow env and envFile to override the variables that pick the network and account, like RECALL_PRIVATE_KEY
+	// +optional
+	allowProtectedEnv bool,
+	// Minimum level of the pipeline's log messages: debug, info (the default), warn or error
+	// +optional
+	logLevel string,
+	// OTLP/HTTP endpoint to also export the phase spans to, e.g. http://collector:4318
+	// +optional
+	otlpEndpoint string,
+	// Return the plan of what would run instead of running it
+	// +optional
+	dryRun bool,
+	// Append the tail of the localnet logs to the error when localnet doesn't come up or the integration tests fail
+	// +optional
+	// +default=true
+	dumpLocalnetLogsOnFailure bool,
 	source *dagger.Directory,
-) (string, error) {
-	log.SetOutput(os.Stdout)
-	log.SetFlags(log.Ltime | log.Lmsgprefix)
+) (_ *TestResult, err error) {
+	return m.test(ctx, testOpts{
+		localnetImage:             localnetImage,
+		registry:                  registry,
+		requireDigest:             requireDigest,
+		dockerUsername:            dockerUsername,
+		dockerPassword:            dockerPassword,
+		rustImage:                 rustImage,
+		rustToolchain:             rustToolchain,
+		extraPackages:             extraPackages,
+		testFilter:                testFilter,
+		lintTarget:                lintTarget,
+		unitTarget:                unitTarget,
+		sdkTarget:                 sdkTarget,
+		cliTarget:                 cliTarget,
+		docTarget:                 docTarget,
+		skip:                      skip,
+		keepGoing:                 keepGoing,
+		integrationRetries:        integrationRetries,
+		localnetTimeout:           localnetTimeout,
+		localnetHeightDelta:       localnetHeightDelta,
+		rpcPort:                   rpcPort,
+		evmRpcPort:                evmRpcPort,
+		objectApiPort:             objectApiPort,
+		parentEvmRpcPort:          parentEvmRpcPort,
+		localnetTopology:          localnetTopology,
+		reuseLocalnetState:        reuseLocalnetState,
+		genesisOverride:           genesisOverride,
+		localnetMemoryLimit:       localnetMemoryLimit,
+		localnetCpuLimit:          localnetCpuLimit,
+		localnetContainer:         localnetContainer,
+		localnetBuildContext:      localnetBuildContext,
+		requireLocalnet:           requireLocalnet,
+		testTargetNetwork:         testTargetNetwork,
+		networkRpcUrl:             networkRpcUrl,
+		networkObjectApiUrl:       networkObjectApiUrl,
+		networkEvmRpcUrl:          networkEvmRpcUrl,
+		networkPrivateKey:         networkPrivateKey,
+		junitOutput:               junitOutput,
+		testAccountSeed:           testAccountSeed,
+		testAccount:               testAccount,
+		testAccountCount:          testAccountCount,
+		allowValidatorAccounts:    allowValidatorAccounts,
+		useSccache:                useSccache,
+		cacheNamespace:            cacheNamespace,
+		features:                  features,
+		noDefaultFeatures:         noDefaultFeatures,
+		profile:                   profile,
+		rustFlags:                 rustFlags,
+		phaseTimeout:              phaseTimeout,
+		offline:                   offline,
+		vendor:                    vendor,
+		fixtures:                  fixtures,
+		gitToken:                  gitToken,
+		cratesMirror:              cratesMirror,
+		httpsProxy:                httpsProxy,
+		networksToml:              networksToml,
+		env:                       env,
+		envFile:                   envFile,
+		allowProtectedEnv:         allowProtectedEnv,
+		logLevel:                  logLevel,
+		otlpEndpoint:              otlpEndpoint,
+		dryRun:                    dryRun,
+		dumpLocalnetLogsOnFailure: dumpLocalnetLogsOnFailure,
+		source:                    source,
+	})
+}
 
-	containerWithAuth, err := m.getContainerWithAuth(dockerUsername, dockerPassword)
+// testOpts are the arguments of Test, so that All, TestWithArtifacts and TestRef can run the tests with only the
+// ones they set, and the rest left at their defaults.
+type testOpts struct {
+	localnetImage             string
+	registry                  string
+	requireDigest             bool
+	dockerUsername            string
+	dockerPassword            *dagger.Secret
+	rustImage                 string
+	rustToolchain             string
+	extraPackages             []string
+	testFilter                string
+	lintTarget                string
+	unitTarget                string
+	sdkTarget                 string
+	cliTarget                 string
+	docTarget                 string
+	skip                      []string
+	keepGoing                 bool
+	integrationRetries        int
+	localnetTimeout           string
+	localnetHeightDelta       int
+	rpcPort                   int
+	evmRpcPort                int
+	objectApiPort             int
+	parentEvmRpcPort          int
+	localnetTopology          string
+	reuseLocalnetState        bool
+	genesisOverride           *dagger.File
+	localnetMemoryLimit       string
+	localnetCpuLimit          string
+	localnetContainer         *dagger.Container
+	localnetBuildContext      *dagger.Directory
+	requireLocalnet           bool
+	testTargetNetwork         string
+	networkRpcUrl             string
+	networkObjectApiUrl       string
+	networkEvmRpcUrl          string
+	networkPrivateKey         *dagger.Secret
+	junitOutput               bool
+	testAccountSeed           int
+	testAccount               string
+	testAccountCount          int
+	allowValidatorAccounts    bool
+	useSccache                bool
+	cacheNamespace            string
+	features                  []string
+	noDefaultFeatures         bool
+	profile                   string
+	rustFlags                 string
+	phaseTimeout              string
+	offline                   bool
+	vendor                    *dagger.Directory
+	fixtures                  *dagger.Directory
+	gitToken                  *dagger.Secret
+	cratesMirror              string
+	httpsProxy                string
+	networksToml              *dagger.File
+	env                       []string
+	envFile                   *dagger.File
+	allowProtectedEnv         bool
+	logLevel                  string
+	otlpEndpoint              string
+	dryRun                    bool
+	dumpLocalnetLogsOnFailure bool
+	source                    *dagger.Directory
+}
+
+// test runs the tests as Test documents, with args in place of its arguments. Unlike Test, it doesn't apply the
+// +default values, so callers set dumpLocalnetLogsOnFailure themselves.
+func (m *Ci) test(ctx context.Context, args testOpts) (_ *TestResult, err error) {
+	level, err := parseLogLevel(args.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	if args.logLevel != "" {
+		setLogLevel(level)
+	}
+	if args.otlpEndpoint != "" {
+		if err := validateOtlpEndpoint(args.otlpEndpoint); err != nil {
+			return nil, err
+		}
+	}
+	topology, err := parseLocalnetTopology(args.localnetTopology)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := parseLocalnetLimits(args.localnetMemoryLimit, args.localnetCpuLimit)
+	if err != nil {
+		return nil, err
+	}
+	if args.genesisOverride != nil && args.reuseLocalnetState {
+		return nil, fmt.Errorf("reuseLocalnetState resumes the chain of the image's genesis, it can't be combined " +
+			"with genesisOverride")
+	}
+	accounts, err := newTestAccountPicker(
+		args.testAccountSeed, args.testAccount, args.allowValidatorAccounts, topology, args.testAccountCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if args.testAccountCount > 1 && args.testTargetNetwork != "" {
+		return nil, fmt.Errorf("testAccountCount needs the localnet test accounts, against %s the tests only have "+
+			"networkPrivateKey", args.testTargetNetwork)
+	}
+	if args.envFile != nil {
+		contents, err := args.envFile.Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("read envFile: %w", err)
+		}
+		fileEnv, err := parseEnvFile(contents)
+		if err != nil {
+			return nil, fmt.Errorf("parse envFile: %w", err)
+		}
+		// The explicit variables come last, so that they take precedence
+		args.env = append(fileEnv, args.env...)
+	}
+	extraEnv, err := parseExtraEnv(args.env, args.allowProtectedEnv)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeEnvPrivateKey(extraEnv); err != nil {
+		return nil, err
+	}
+	if err := validateAptPackages(args.extraPackages); err != nil {
+		return nil, err
+	}
+	if args.rustToolchain != "" && !rustToolchainPattern.MatchString(args.rustToolchain) {
+		return nil, fmt.Errorf("invalid Rust toolchain %q", args.rustToolchain)
+	}
+	if args.cacheNamespace != "" && !cacheNamespacePattern.MatchString(args.cacheNamespace) {
+		return nil, fmt.Errorf("invalid cache namespace %q", args.cacheNamespace)
+	}
+	if err := validateCargoFeatures(args.features); err != nil {
+		return nil, err
+	}
+	if err := validateCargoProfile(ctx, args.source, args.profile); err != nil {
+		return nil, err
+	}
+	if err := validateCargoNetwork(args.cratesMirror, args.httpsProxy); err != nil {
+		return nil, err
+	}
+	if args.cratesMirror != "" && args.vendor != nil {
+		return nil, fmt.Errorf("cratesMirror and vendor both replace crates.io, only one of them can be given")
+	}
+	opts := codeContainerOpts{
+		rustImage:         args.rustImage,
+		rustToolchain:     args.rustToolchain,
+		extraPackages:     args.extraPackages,
+		accounts:          accounts,
+		sccache:           args.useSccache,
+		rustFlags:         args.rustFlags,
+		features:          args.features,
+		noDefaultFeatures: args.noDefaultFeatures,
+		profile:           args.profile,
+		offline:           args.offline,
+		vendor:            args.vendor,
+		fixtures:          args.fixtures,
+		gitToken:          args.gitToken,
+		cratesMirror:      args.cratesMirror,
+		httpsProxy:        args.httpsProxy,
+		env:               extraEnv,
+		cacheNamespace:    args.cacheNamespace,
+	}
+	defaultUnit, defaultSdk := "test", "run-sdk-tests"
+	if args.junitOutput {
+		opts.cargoTools = append(opts.cargoTools, "cargo-nextest")
+		defaultUnit, defaultSdk = "test-nextest", "run-sdk-tests-nextest"
+	}
+	targets := make(map[string]string)
+	for _, phase := range []struct{ name, override, fallback string }{
+		{"lint", args.lintTarget, "lint"},
+		{"unit", args.unitTarget, defaultUnit},
+		{"sdk", args.sdkTarget, defaultSdk},
+		{"cli", args.cliTarget, "run-cli-tests"},
+		{"doc", args.docTarget, "doc"},
+	} {
+		targets[phase.name], err = makeTarget(phase.name, phase.override, phase.fallback)
+		if err != nil {
+			return nil, err
+		}
+	}
+	skipped, err := parseSkipPhases(args.skip)
+	if err != nil {
+		return nil, err
+	}
+	unitCmd, sdkCmd := "make "+targets["unit"], "make "+targets["sdk"]
+	if args.junitOutput {
+		unitCmd += " && " + copyJunitReport("unit")
+		sdkCmd += " && " + copyJunitReport("sdk")
+	}
+	network, err := newExternalNetwork(
+		ctx, args.testTargetNetwork, args.networkRpcUrl, args.networkObjectApiUrl, args.networkEvmRpcUrl,
+		args.networkPrivateKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if args.networksToml != nil {
+		content, err := args.networksToml.Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		networkName := "localnet"
+		if network != nil {
+			networkName = network.name
+		}
+		if err := validateNetworksToml(content, networkName); err != nil {
+			return nil, err
+		}
+		opts.networksToml = content
+	}
+	var (
+		codeContainer *dagger.Container
+		localnet      *dagger.Service
+	)
+	if network != nil {
+		codeContainer, err = m.externalSetup(
+			ctx, args.registry, args.dockerUsername, args.dockerPassword, args.source, network, opts,
+		)
+	} else {
+		codeContainer, localnet, err = m.localnetSetup(
+			ctx, args.localnetImage, args.registry, args.dockerUsername, args.dockerPassword, args.source,
+			localnetOpts{
+				ports: localnetPorts{
+					rpc: args.rpcPort, evmRpc: args.evmRpcPort, objectApi: args.objectApiPort, parentEvmRpc: args.parentEvmRpcPort,
+				},
+				topology:      topology,
+				requireDigest: args.requireDigest,
+				reuseState:    args.reuseLocalnetState,
+				genesis:       args.genesisOverride,
+				limits:        limits,
+				container:     args.localnetContainer,
+				buildContext:  args.localnetBuildContext,
+			},
+			opts,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if localnet != nil {
+		defer func() {
+			if err != nil {
+				stopLocalnet(ctx, localnet)
+			}
+		}()
+	}
+	timeout, err := parseLocalnetTimeout(args.localnetTimeout)
+	if err != nil {
+		return nil, err
+	}
+	heightDelta, err := parseMinHeightDelta(args.localnetHeightDelta)
+	if err != nil {
+		return nil, err
+	}
+	phaseLimit, err := parsePhaseTimeout(args.phaseTimeout)
+	if err != nil {
+		return nil, err
+	}
+	codeContainer = codeContainer.WithEnvVariable("TEST_FILTER", args.testFilter)
+
+	if args.dryRun {
+		readiness := planStep{"localnet", fmt.Sprintf("wait for localnet to produce %d more blocks", heightDelta)}
+		if network != nil {
+			readiness = planStep{network.name, "check that the network's RPC endpoint responds"}
+		}
+		steps := []planStep{{"build", buildCommand(opts)}}
+		for _, step := range []planStep{
+			{"lint", "make " + targets["lint"]},
+			{"unit", unitCmd},
+			readiness,
+			{"sdk", sdkCmd},
+			{"cli", "make " + targets["cli"]},
+			{"doc", "make " + targets["doc"]},
+		} {
+			switch {
+			case skipped[step.phase]:
+				step.cmd = "skipped"
+			case step == readiness && skipped["sdk"] && skipped["cli"]:
+				continue
+			}
+			steps = append(steps, step)
+		}
+		plan, err := describePlan(ctx, codeContainer, opts, steps)
+		if err != nil {
+			return nil, err
+		}
+		return &TestResult{Plan: plan}, nil
+	}
+
+	if args.otlpEndpoint != "" {
+		tracer, shutdown, err := otlpTracer(ctx, args.otlpEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		defer shutdown()
+		ctx = withPhaseTracer(ctx, tracer)
+	}
+
+	result := &TestResult{}
+	if localnet != nil {
+		result.LocalnetLogs = localnetLogs(codeContainer)
+	}
+	var buildOutput string
+	err = runPhase(ctx, "build", phaseLimit, func(ctx context.Context) (err error) {
+		buildOutput, err = codeContainer.Stdout(ctx)
+		return err
+	})
+	logPhaseOutput("build", buildOutput, err)
+	if err != nil {
+		return nil, newPhaseError("build", buildCommand(opts), err)
+	}
+	if args.useSccache {
+		result.Sccache = sccacheSummary(buildOutput)
+	}
+	// stop records a failed phase, reporting whether the run should end with it, which it does unless keepGoing is set
+	var failedPhases []string
+	var failures []error
+	stop := func(phase string, err error) bool {
+		failedPhases = append(failedPhases, phase)
+		failures = append(failures, err)
+		return !args.keepGoing
+	}
+
+	// Each phase runs in its own container on top of the build, so that its output is only its own
+	var unitContainer *dagger.Container
+	for _, phase := range []struct {
+		name, cmd string
+		result    **PhaseResult
+		container **dagger.Container
+	}{
+		{"lint", "make " + targets["lint"], &result.Lint, nil},
+		{"unit", unitCmd, &result.Unit, &unitContainer},
+	} {
+		if skipped[phase.name] {
+			*phase.result = skippedPhase()
+			continue
+		}
+		phaseContainer := codeContainer.WithExec([]string{"sh", "-c", phase.cmd})
+		var stdout string
+		start := time.Now()
+		err := runPhase(ctx, phase.name, phaseLimit, func(ctx context.Context) (err error) {
+			stdout, err = phaseContainer.Stdout(ctx)
+			return err
+		})
+		logPhaseOutput(phase.name, stdout, err)
+		*phase.result = newPhaseResult(stdout, time.Since(start), err)
+		if err != nil {
+			err = newPhaseError(phase.name, phase.cmd, err)
+			if stop(phase.name, err) {
+				return result, err
+			}
+			continue
+		}
+		if phase.container != nil {
+			*phase.container = phaseContainer
+		}
+	}
+
+	// SDK and CLI integration tests
+	var integrationSuites []integrationSuite
+	for _, suite := range []integrationSuite{
+		{name: "sdk", cmd: sdkCmd},
+		{name: "cli", cmd: "make " + targets["cli"]},
+	} {
+		if !skipped[suite.name] {
+			integrationSuites = append(integrationSuites, suite)
+		}
+	}
+	if skipped["sdk"] {
+		result.Sdk = skippedPhase()
+	}
+	if skipped["cli"] {
+		result.Cli = skippedPhase()
+	}
+	var suites []suiteResult
+	switch {
+	case len(integrationSuites) == 0:
+		// Both suites are skipped, so there is no need to wait for the network
+	case network == nil:
+		err := m.waitForLocalnet(ctx, codeContainer, localnet, timeout, heightDelta)
+		if err == nil && topology == singleNodeTopology && args.genesisOverride == nil {
+			// Running with more validators than asked for means the tests may pick a validator's account, so this
+			// fails the run instead of skipping the integration tests
+			if err := m.checkLocalnetValidators(ctx, codeContainer, localnet, 1); err != nil {
+				return result, newPhaseError("localnet", "check that localnet runs a single validator", err)
+			}
+		}
+		if err != nil {
+			err = withLocalnetLimits(ctx, codeContainer, limits, err)
+		}
+		if err != nil && args.dumpLocalnetLogsOnFailure {
+			err = withLocalnetLogs(ctx, codeContainer, err)
+		}
+		if err != nil {
+			err = newPhaseError("localnet", "wait for localnet to produce blocks", err)
+		}
+		switch {
+		case err != nil && args.requireLocalnet:
+			if stop("localnet", err) {
+				return result, err
+			}
+			// With keepGoing the suites are reported as failed with the localnet error, not as skipped
+			markUnreachable(result, newPhaseResult("", 0, err))
+		case err != nil:
+			warnf("skipping the integration tests, localnet is unreachable: %v", err)
+			unreachable := &PhaseResult{Stdout: "skipped, localnet is unreachable: " + err.Error() + "\n", Skipped: true}
+			markUnreachable(result, unreachable)
+		default:
+			suiteAccounts := accounts
+			if hasEnvVar(extraEnv, "RECALL_PRIVATE_KEY") {
+				// The suites share the account from env instead of getting their own
+				suiteAccounts = nil
+			}
+			suites = m.runIntegrationSuites(
+				ctx, codeContainer, integrationSuites, suiteAccounts, args.integrationRetries, phaseLimit,
+			)
+		}
+	default:
+		reachable, err := checkNetwork(ctx, codeContainer, network.name)
+		if err != nil {
+			return result, err
+		}
+		if reachable {
+			// All the suites share the one funded account
+			suites = m.runIntegrationSuites(ctx, codeContainer, integrationSuites, nil, args.integrationRetries, phaseLimit)
+		} else {
+			unreachable := &PhaseResult{Stdout: "skipped, network " + network.name + " is unreachable\n", Skipped: true}
+			markUnreachable(result, unreachable)
+		}
+	}
+	if len(suites) > 0 {
+		var failed []string
+		var errs []error
+		for _, suite := range suites {
+			if suite.name == "sdk" {
+				result.Sdk = suite.phaseResult()
+			} else {
+				result.Cli = suite.phaseResult()
+			}
+			if suite.err != nil {
+				failed = append(failed, suite.name)
+				errs = append(errs, suite.err)
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			if network == nil {
+				err = withLocalnetLimits(ctx, codeContainer, limits, err)
+			}
+			if args.dumpLocalnetLogsOnFailure && network == nil {
+				err = withLocalnetLogs(ctx, codeContainer, err)
+			}
+			if stop(strings.Join(failed, ", "), err) {
+				return result, err
+			}
+		}
+	}
+
+	// Docs
+	if skipped["doc"] {
+		result.Doc = skippedPhase()
+	} else {
+		var stdout string
+		start := time.Now()
+		err = runPhase(ctx, "doc", phaseLimit, func(ctx context.Context) (err error) {
+			stdout, err = codeContainer.WithExec([]string{"sh", "-c", "make " + targets["doc"]}).Stdout(ctx)
+			return err
+		})
+		logPhaseOutput("doc", stdout, err)
+		result.Doc = newPhaseResult(stdout, time.Since(start), err)
+		if err != nil {
+			err = newPhaseError("doc", "make "+targets["doc"], err)
+		}
+		if err != nil && stop("doc", err) {
+			return result, err
+		}
+	}
+
+	if args.junitOutput {
+		var reports []*dagger.File
+		if unitContainer != nil {
+			reports = append(reports, junitReport(unitContainer, "unit"))
+		}
+		for _, suite := range suites {
+			if suite.name == "sdk" {
+				reports = append(reports, junitReport(suite.container, "sdk"))
+			}
+		}
+		if len(reports) > 0 {
+			result.Report, err = mergeJunitReports(ctx, reports...)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return result, fmt.Errorf("%s failed: %w", strings.Join(failedPhases, ", "), errors.Join(failures...))
+	}
+	result.Passed = true
+	return result, nil
+}
+
+// markUnreachable sets the SDK and CLI results that aren't set yet, i.e. those of the suites that weren't skipped as
+// requested, to unreachable, the result of suites that couldn't reach the network.
+func markUnreachable(result *TestResult, unreachable *PhaseResult) {
+	if result.Sdk == nil {
+		result.Sdk = unreachable
+	}
+	if result.Cli == nil {
+		result.Cli = unreachable
+	}
+}
+
+// TestResult is the result of a Test run. Phases that didn't get to run because an earlier one failed are nil.
+type TestResult struct {
+	// Whether every phase passed
+	Passed bool
+	// Cache hit rates of the build, only set when useSccache is requested
+	Sccache string
+	Lint    *PhaseResult
+	Unit    *PhaseResult
+	Sdk     *PhaseResult
+	Cli     *PhaseResult
+	Doc     *PhaseResult
+	// JUnit XML report of the unit and SDK tests, only set when junitOutput is requested
+	Report *dagger.File
+	// Output of the localnet service, only set when the tests ran against localnet
+	LocalnetLogs *dagger.File
+	// What the run would do, only set when dryRun is requested, in which case nothing else is
+	Plan string
+}
+
+// PhaseResult is the outcome of one phase of a Test run.
+type PhaseResult struct {
+	Stdout string
+	Passed bool
+	// Set when the phase didn't run, e.g. because the target network was unreachable
+	Skipped bool
+	// How long the phase took, as a Go duration (e.g. "1m30s")
+	Duration string
+	// Why the phase failed, including the output of the failed command
+	Error string
+}
+
+func newPhaseResult(stdout string, duration time.Duration, err error) *PhaseResult {
+	result := &PhaseResult{Stdout: stdout, Passed: err == nil, Duration: duration.Round(time.Millisecond).String()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// String returns the combined output of all the phases that ran, with the lines of the SDK and CLI tests prefixed by
+// the suite name, or the plan of a dry run.
+func (r *TestResult) String() string {
+	if r.Plan != "" {
+		return r.Plan
+	}
+	var output strings.Builder
+	output.WriteString(r.Sccache)
+	for _, phase := range []struct {
+		prefix string
+		result *PhaseResult
+	}{
+		{"", r.Lint},
+		{"", r.Unit},
+		{"[sdk] ", r.Sdk},
+		{"[cli] ", r.Cli},
+		{"", r.Doc},
+	} {
+		if phase.result != nil {
+			output.WriteString(prefixLines(phase.prefix, phase.result.Stdout))
+		}
+	}
+	return output.String()
+}
+
+// integrationSuite is a test suite that runs against localnet.
+type integrationSuite struct {
+	name string
+	// Shell command that runs the suite
+	cmd string
+}
+
+// suiteResult is the outcome of running an integrationSuite.
+type suiteResult struct {
+	name   string
+	stdout string
+	// Container the suite ran in, for collecting any files it produced
+	container *dagger.Container
+	duration  time.Duration
+	err       error
+}
+
+// runIntegrationSuites runs the integration suites concurrently in separate containers that share the localnet
+// service bound to the given container. Each suite gets test accounts of its own from accounts, unless an account is
+// pinned, there aren't enough accounts to go around or accounts is nil, in which case the suites run one after the
+// other with the same accounts or the one already set on the container, so that they don't clash on nonces. A suite
+// that fails because localnet couldn't be reached is retried up to retries times, and each suite, including its
+// retries, may take up to timeout if it is non-zero.
+func (m *Ci) runIntegrationSuites(
+	ctx context.Context,
+	container *dagger.Container,
+	suites []integrationSuite,
+	accounts *testAccountPicker,
+	retries int,
+	timeout time.Duration,
+) []suiteResult {
+	// Each suite gets accounts of its own so that their concurrent transactions don't clash on nonces
+	privateKeys := make([][]string, len(suites))
+	sequential := accounts == nil || accounts.pinned != nil
+	if !sequential {
+		perSuite := accounts.perSuite()
+		keys, err := accounts.privateKeys(perSuite * len(suites))
+		if err != nil {
+			warnf("not enough test accounts for each suite to get %d of its own, running the suites one after the "+
+				"other: %v", perSuite, err)
+			sequential = true
+		} else {
+			for i := range suites {
+				privateKeys[i] = keys[i*perSuite : (i+1)*perSuite]
+			}
+		}
+	}
+	if accounts != nil && sequential {
+		// The suites share one set of accounts since they run one after the other, which can't fail as the picker
+		// checked that there are enough for one suite
+		keys, _ := accounts.privateKeys(accounts.perSuite())
+		for i := range suites {
+			privateKeys[i] = keys
+		}
+	}
+
+	results := make([]suiteResult, len(suites))
+	var wg sync.WaitGroup
+	for i, suite := range suites {
+		wg.Add(1)
+		suiteContainer := container
+		for _, envVar := range testAccountEnv(privateKeys[i]) {
+			suiteContainer = suiteContainer.WithEnvVariable(envVar.name, envVar.value)
+		}
+		run := func() {
+			defer wg.Done()
+			start := time.Now()
+			results[i] = suiteResult{name: suite.name}
+			err := runPhase(ctx, suite.name, timeout, func(ctx context.Context) (err error) {
+				results[i].container, results[i].stdout, err = runWithRetry(
+					ctx,
+					suiteContainer,
+					[]string{"sh", "-c", suite.cmd},
+					retries+1,
+					5*time.Second,
+				)
+				return err
+			})
+			results[i].duration = time.Since(start)
+			logPhaseOutput(suite.name, results[i].stdout, err)
+			if err != nil {
+				results[i].err = newPhaseError(suite.name, suite.cmd, err)
+			}
+		}
+		if sequential {
+			run()
+		} else {
+			go run()
+		}
+	}
+	wg.Wait()
+	return results
+}
+
+func (r suiteResult) phaseResult() *PhaseResult {
+	return newPhaseResult(r.stdout, r.duration, r.err)
+}
+
+// prefixLines prepends prefix to every line of s.
+func prefixLines(prefix, s string) string {
+	if s == "" {
+		return ""
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(prefix)
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// Build compiles the workspace and returns the release `recall` binary. It shares the cargo cache volumes with Test, so
+// a subsequent Test run starts from a warm build.
+func (m *Ci) Build(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// Fail unless localnetImage is pinned by digest (e.g. textile/recall-localnet@sha256:...), for reproducible runs
+	// +optional
+	requireDigest bool,
+	// Cargo features to enable, optionally qualified by package, e.g. recall_sdk/foo
+	// +optional
+	features []string,
+	// Disable the default features
+	// +optional
+	noDefaultFeatures bool,
+	// Cargo profile to build with: release (the default), dev or a custom profile from Cargo.toml
+	// +optional
+	profile string,
+	// Token for fetching private git dependencies over HTTPS, e.g. a GitHub token with read access to them
+	// +optional
+	gitToken *dagger.Secret,
+	// Registry index to fetch crates from instead of crates.io, e.g. sparse+https://mirror.example.com/index/
+	// +optional
+	cratesMirror string,
+	// Proxy for the HTTP and HTTPS requests of the build, e.g. http://proxy.example.com:3128
+	// +optional
+	httpsProxy string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.File, error) {
+	if err := validateCargoFeatures(features); err != nil {
+		return nil, err
+	}
+	if err := validateCargoProfile(ctx, source, profile); err != nil {
+		return nil, err
+	}
+	if err := validateCargoNetwork(cratesMirror, httpsProxy); err != nil {
+		return nil, err
+	}
+	codeContainer, _, err := m.setup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{requireDigest: requireDigest},
+		codeContainerOpts{
+			features: features, noDefaultFeatures: noDefaultFeatures, profile: profile,
+			gitToken: gitToken, cratesMirror: cratesMirror, httpsProxy: httpsProxy,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	binary, err := extractBinary(codeContainer, "/src/target/"+profileDir(profile)+"/recall").Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("build recall binary: %w", err)
+	}
+	return binary, nil
+}
+
+// Lint checks formatting and runs clippy. The two checks run as separate steps so that a failure names the one that
+// failed. Unlike Test, it doesn't build the CLI or need localnet, so it runs in a container with only the system
+// packages the checks need, sharing the cargo caches of the full one.
+func (m *Ci) Lint(
+	ctx context.Context,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", registry, dockerUsername, dockerPassword)
 	if err != nil {
 		return "", err
 	}
-	localnetContainer, err := m.getLocalnetImage(containerWithAuth, localnetImage)
+	source = filterSource(source)
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{lightweight: true})
 	if err != nil {
 		return "", err
 	}
-
-	networksTomlContent, err := localnetContainer.
-		File("/workdir/localnet-data/networks.toml").
-		Contents(ctx)
+	start := time.Now()
+	container, err := m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		Sync(ctx)
 	if err != nil {
 		return "", err
 	}
-	// Replace "localhost" with "localnet" in the networks.toml content
-	networksTomlContent = strings.ReplaceAll(networksTomlContent, "localhost", "localnet")
+	infof("lint container set up in %s", time.Since(start).Round(time.Millisecond))
+
+	var output strings.Builder
+	for _, step := range []string{"check-fmt", "check-clippy"} {
+		container = container.WithExec([]string{"sh", "-c", "make " + step})
+		stdout, err := container.Stdout(ctx)
+		if err != nil {
+			return output.String(), &LintError{Step: step, Err: err}
+		}
+		output.WriteString(stdout)
+	}
+	return output.String(), nil
+}
+
+// LintError is returned by Lint when one of its steps fails.
+type LintError struct {
+	// Step is the make target that failed
+	Step string
+	Err  error
+}
+
+func (e *LintError) Error() string {
+	return fmt.Sprintf("lint step %s failed: %v", e.Step, e.Err)
+}
+
+func (e *LintError) Unwrap() error {
+	return e.Err
+}
 
-	// Exclude the target and dagger directories from the sources
-	source = source.
+// extractBinary checks that the binary at path exists after a build and returns a copy of it. The target directory
+// is a cache volume, which can't be read back from the container, so the binary has to be copied out of it.
+func extractBinary(container *dagger.Container, path string) *dagger.File {
+	return container.
+		WithExec([]string{
+			"sh", "-c",
+			"test -f " + path + " || { echo 'recall binary not found at " + path + " after build' >&2; exit 1; }",
+		}).
+		WithExec([]string{"cp", path, "/recall"}).
+		File("/recall")
+}
+
+// localnetSetup is setup with the localnet service bound into the code container, which is what the integration
+// tests run in.
+func (m *Ci) localnetSetup(
+	ctx context.Context,
+	localnetImage string,
+	registry string,
+	dockerUsername string,
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+	localnet localnetOpts,
+	opts codeContainerOpts,
+) (*dagger.Container, *dagger.Service, error) {
+	container, service, err := m.setup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnet, opts,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return container.WithServiceBinding("localnet", service), service, nil
+}
+
+// setup prepares the code container with the CLI built and installed, along with the localnet service it is
+// configured against.
+func (m *Ci) setup(
+	ctx context.Context,
+	localnetImage string,
+	registry string,
+	dockerUsername string,
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+	localnet localnetOpts,
+	opts codeContainerOpts,
+) (*dagger.Container, *dagger.Service, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", registry, dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+	service, localnetConfig, logFile, err := m.localnet(ctx, containerWithAuth, registry, localnetImage, localnet)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source = filterSource(source)
+	opts, err = keyTargetCache(ctx, source, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.networksToml != "" {
+		warnf("using the given networks.toml instead of the one from the localnet image")
+	}
+	codeContainer, err := m.codeContainer(containerWithAuth, source, renderNetworksToml("localnet", localnetConfig), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	codeContainer = codeContainer.
+		WithMountedCache(localnetLogDir, localnetLogCache).
+		WithEnvVariable("LOCALNET_LOG_FILE", logFile)
+	return codeContainer, service, nil
+}
+
+// filterSource excludes the git, target and dagger directories from the sources.
+func filterSource(source *dagger.Directory) *dagger.Directory {
+	return source.
 		WithoutDirectory(".git").
 		WithoutDirectory("target").
 		WithoutDirectory("dagger")
-	codeContainer, err := m.codeContainer(containerWithAuth, source, networksTomlContent)
-	if err != nil {
-		return "", err
-	}
-	return codeContainer.
-		WithServiceBinding("localnet", m.localnetService(localnetContainer)).
-		WithExec([]string{"sh", "-c", "make lint"}).          // Lint
-		WithExec([]string{"sh", "-c", "make test"}).          // Unit tests
-		WithExec([]string{"sh", "-c", "make run-sdk-tests"}). // SDK integration tests
-		WithExec([]string{"sh", "-c", "make run-cli-tests"}). // CLI integration tests
-		WithExec([]string{"sh", "-c", "make doc"}).           // Docs
-		Stdout(ctx)
 }
 
 func (m *Ci) getLocalnetImage(
+	ctx context.Context,
 	containerWithAuth *dagger.Container,
+	registry string,
 	localnetImage string,
+	localnet localnetOpts,
 ) (*dagger.Container, error) {
+	if localnet.container != nil || localnet.buildContext != nil {
+		return localImage(localnetImage, localnet)
+	}
+	requireDigest := localnet.requireDigest
 	if localnetImage == "" {
 		localnetImage = "textile/recall-localnet"
 	}
-	return containerWithAuth.From(localnetImage), nil
+	pinned := strings.Contains(localnetImage, "@sha256:")
+	if requireDigest && !pinned {
+		return nil, fmt.Errorf("localnet image %q isn't pinned by digest, expected <image>@sha256:<digest>", localnetImage)
+	}
+	// Images that don't name a registry are pulled from the configured one
+	if registry != "" && registry != defaultRegistry && !hasRegistryHost(localnetImage) {
+		localnetImage = registry + "/" + localnetImage
+	}
+	container := containerWithAuth.From(localnetImage)
+	if !pinned {
+		// Tags can move, so record which image the tag pointed at for this run
+		ref, err := container.ImageRef(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("resolve localnet image %s: %w", localnetImage, err)
+		}
+		infof("localnet image %s resolved to %s", localnetImage, ref)
+	}
+	return container, nil
+}
+
+// hasRegistryHost reports whether an image reference starts with a registry host, which Docker tells apart from a
+// Docker Hub namespace by it containing a dot or a port, or being localhost.
+func hasRegistryHost(image string) bool {
+	host, _, found := strings.Cut(image, "/")
+	return found && (strings.ContainsAny(host, ".:") || host == "localhost")
 }
 
 func (m *Ci) getContainerWithAuth(
+	platform dagger.Platform,
+	registry string,
 	dockerUsername string,
 	dockerPassword *dagger.Secret,
 ) (*dagger.Container, error) {
-	container := dag.Container().
+	if registry == "" {
+		registry = defaultRegistry
+	}
+	if (dockerUsername == "") != (dockerPassword == nil) {
+		return nil, fmt.Errorf("dockerUsername and dockerPassword have to be given together")
+	}
+
+	container := dag.Container(dagger.ContainerOpts{Platform: platform}).
 		WithEnvVariable("DOCKER_BUILDKIT", "1").
 		WithMountedCache("/root/.cache/buildkit", buildkitCache).
 		WithMountedCache("/var/lib/docker", dockerCache)
 
-	if (dockerUsername == "") || (dockerPassword == nil) {
+	if dockerUsername == "" {
+		infof("pulling images from %s without authentication", registry)
 		return container, nil
 	}
 
+	infof("pulling images from %s with authentication", registry)
 	return container.
-		WithRegistryAuth("docker.io", dockerUsername, dockerPassword).
+		WithRegistryAuth(registry, dockerUsername, dockerPassword).
 		WithSecretVariable("DOCKER_PASSWORD", dockerPassword).
 		// Login to Docker so that we don't run into rate limits while pulling images from inside the localnet image
 		WithExec([]string{
 			"sh", "-c",
-			"echo $DOCKER_PASSWORD | docker login -u " + dockerUsername + " --password-stdin",
+			"echo $DOCKER_PASSWORD | docker login -u " + dockerUsername + " --password-stdin " + registry,
 		}), nil
 }
 
+// codeContainerOpts customizes the container built by codeContainer. The zero value gives the default container.
+type codeContainerOpts struct {
+	// Base image, defaults to rust:slim-bookworm
+	rustImage string
+	// Toolchain to install and use instead of the one in rust-toolchain.toml
+	rustToolchain string
+	// Crates to install with `cargo install`, e.g. cargo-llvm-cov
+	cargoTools []string
+	// apt packages to install in addition to the default ones
+	extraPackages []string
+	// Platform of the container, used to keep the target cache of each platform separate
+	platform dagger.Platform
+	// Picks the test account, a randomly seeded one is used when nil
+	accounts *testAccountPicker
+	// Short hash of Cargo.lock, used to keep the target cache of each set of dependencies separate
+	lockfileHash string
+	// RUSTFLAGS for all cargo invocations
+	rustFlags string
+	// Cargo features to enable in the make targets' cargo invocations
+	features []string
+	// Disable the default features in the make targets' cargo invocations
+	noDefaultFeatures bool
+	// Cargo profile that `make build install` uses, release when empty
+	profile string
+	// Only install lightweightAptPackages, for containers that check the code without building or testing it
+	lightweight bool
+	// Directory that `make install` installs the CLI under, through CARGO_INSTALL_ROOT, the cargo home when empty
+	installPrefix string
+	// Short hash of the CPU features, used to keep the target cache of target-cpu=native builds separate per CPU
+	cpuFeaturesHash string
+	// Wrap rustc with sccache, printing its stats after the build
+	sccache bool
+	// Build without network access, from the registry cache or vendor
+	offline bool
+	// Dependencies vendored by Vendor, used instead of the crates.io and git sources when set
+	vendor *dagger.Directory
+	// Test fixtures to mount at testFixturesDir
+	fixtures *dagger.Directory
+	// Token that git authenticates with when cargo fetches git dependencies over HTTPS
+	gitToken *dagger.Secret
+	// Registry index that replaces crates.io
+	cratesMirror string
+	// Proxy for HTTP and HTTPS requests, including the apt and cargo downloads
+	httpsProxy string
+	// Name of the network in networks.toml, defaults to localnet
+	network string
+	// Account to run the tests with instead of one from accounts
+	privateKey *dagger.Secret
+	// Contents of the networks.toml to use instead of the generated one
+	networksToml string
+	// Extra environment variables, set after the ones above so that they can override them
+	env []envVar
+	// Suffix of the cache volume names, to keep concurrent runs from sharing caches
+	cacheNamespace string
+}
+
+// cargoFeaturePattern matches cargo feature names, optionally qualified by a package name as in recall_sdk/foo.
+var cargoFeaturePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_+.-]*(/[A-Za-z0-9_][A-Za-z0-9_+.-]*)?$`)
+
+// validateCargoFeatures checks that features are all valid cargo feature names.
+func validateCargoFeatures(features []string) error {
+	for _, feature := range features {
+		if !cargoFeaturePattern.MatchString(feature) {
+			return fmt.Errorf("invalid cargo feature %q", feature)
+		}
+	}
+	return nil
+}
+
+// cargoFeatureFlags returns the cargo flags that select the features of opts, or an empty string for the defaults.
+func cargoFeatureFlags(opts codeContainerOpts) string {
+	var flags []string
+	if opts.noDefaultFeatures {
+		flags = append(flags, "--no-default-features")
+	}
+	if len(opts.features) > 0 {
+		flags = append(flags, "--features", strings.Join(opts.features, ","))
+	}
+	return strings.Join(flags, " ")
+}
+
+// cacheNamespacePattern matches the cache namespaces that can be used in cache volume names.
+var cacheNamespacePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// cacheName returns the name of the cache volume called name in the cache namespace of opts.
+func (opts codeContainerOpts) cacheName(name string) string {
+	if opts.cacheNamespace == "" {
+		return name
+	}
+	return name + "-" + opts.cacheNamespace
+}
+
+// cargoTargetKey returns the name of the target cache volume for the builds of opts.
+func (opts codeContainerOpts) cargoTargetKey() string {
+	key := "cargo-target"
+	if opts.platform != "" {
+		key += "-" + platformName(opts.platform)
+	}
+	if opts.lockfileHash != "" {
+		key += "-" + opts.lockfileHash
+	}
+	if opts.cpuFeaturesHash != "" {
+		key += "-cpu-" + opts.cpuFeaturesHash
+	}
+	// Builds with other features would rebuild the crates whose features changed over each other's artifacts
+	if featureFlags := cargoFeatureFlags(opts); featureFlags != "" {
+		key += "-features-" + lockfileHash(featureFlags)
+	}
+	if opts.profile != "" && opts.profile != "release" {
+		key += "-profile-" + opts.profile
+	}
+	// Other compilers and codegen flags produce artifacts that overwrite each other's instead of being reused
+	if opts.rustToolchain != "" {
+		key += "-toolchain-" + lockfileHash(opts.rustToolchain)
+	}
+	if opts.rustFlags != "" {
+		key += "-rustflags-" + lockfileHash(opts.rustFlags)
+	}
+	return opts.cacheName(key)
+}
+
+// withLockfile returns opts with the lockfile hash of the Cargo.lock contents lockfile.
+func (opts codeContainerOpts) withLockfile(lockfile string) codeContainerOpts {
+	opts.lockfileHash = lockfileHash(lockfile)
+	return opts
+}
+
+// keyTargetCache sets the lockfile hash of opts from the Cargo.lock in source, so that builds with different
+// dependencies don't share a target cache. The registry and git caches stay shared since they are only ever added to.
+//
+// When the rustflags ask for target-cpu=native, the artifacts only run on CPUs with the same features as the one they
+// were built on, and a binary built on one runner can crash with SIGILL on another. The target cache is then also
+// keyed by the CPU features of the engine, which keeps the speedup of native codegen at the cost of a separate cache
+// per runner type.
+func keyTargetCache(
+	ctx context.Context,
+	source *dagger.Directory,
+	opts codeContainerOpts,
+) (codeContainerOpts, error) {
+	lockfile, err := source.File("Cargo.lock").Contents(ctx)
+	if err != nil {
+		return opts, fmt.Errorf("read Cargo.lock: %w", err)
+	}
+	opts = opts.withLockfile(lockfile)
+	if strings.Contains(opts.rustFlags, "target-cpu=native") {
+		features, err := dag.Container(dagger.ContainerOpts{Platform: opts.platform}).
+			From(rustImageRef(opts)).
+			// The features of the CPU this runs on, never ones cached from another runner
+			WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+			WithExec([]string{"sh", "-c", "grep -m1 -E '^(flags|Features)' /proc/cpuinfo"}).
+			Stdout(ctx)
+		if err != nil {
+			return opts, fmt.Errorf("detect CPU features: %w", err)
+		}
+		opts.cpuFeaturesHash = lockfileHash(features)
+		warnf("building with target-cpu=native, keying the target cache by CPU features %s",
+			opts.cpuFeaturesHash)
+	}
+	return opts, nil
+}
+
+// lockfileHash returns a short hash of content for use in cache keys.
+func lockfileHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
 func (m *Ci) codeContainer(
 	containerWithAuth *dagger.Container,
 	source *dagger.Directory,
 	networksTomlContent string,
+	opts codeContainerOpts,
 ) (*dagger.Container, error) {
-	// Create Rust-specific caches
-	cargoRegistry := dag.CacheVolume("cargo-registry")
-	cargoGit := dag.CacheVolume("cargo-git")
-	cargoTarget := dag.CacheVolume("cargo-target")
-	rustupCache := dag.CacheVolume("rustup-cache")
+	network := opts.network
+	if network == "" {
+		network = "localnet"
+	}
+	container := m.rustContainer(containerWithAuth, opts)
+	if opts.vendor != nil {
+		container = container.
+			WithDirectory("/vendor", opts.vendor).
+			WithFile("/root/.cargo/config.toml", opts.vendor.File(vendorCargoConfig))
+	}
+	if opts.offline {
+		// Check up front that everything is available locally, so that a missing dependency fails with a clear message
+		// instead of somewhere in the middle of the build
+		container = container.
+			WithEnvVariable("CARGO_NET_OFFLINE", "true").
+			WithDirectory("/src", source).
+			WithWorkdir("/src").
+			WithExec([]string{
+				"sh", "-c",
+				"cargo fetch --locked || { echo 'offline build: dependencies are missing from the registry cache " +
+					"and vendor directory, run an online build or Ci.Vendor to populate them' >&2; exit 1; }",
+			})
+	}
+	if opts.networksToml != "" {
+		networksTomlContent = opts.networksToml
+	}
+	rpcUrl, err := networkRpcUrl(networksTomlContent, network)
+	if err != nil {
+		return nil, err
+	}
+	container = container.
+		WithNewFile("/root/.config/recall/networks.toml", networksTomlContent).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithEnvVariable("RECALL_NETWORK_CONFIG_FILE", "/root/.config/recall/networks.toml").
+		WithEnvVariable("RECALL_NETWORK", network).
+		WithEnvVariable(networkRpcUrlEnv, rpcUrl)
+	if opts.privateKey != nil {
+		container = container.WithSecretVariable("RECALL_PRIVATE_KEY", opts.privateKey)
+	} else {
+		accounts := opts.accounts
+		if accounts == nil {
+			accounts = &testAccountPicker{rand: newAccountRand(0)}
+		}
+		keys, err := accounts.privateKeys(accounts.perSuite())
+		if err != nil {
+			return nil, err
+		}
+		for _, envVar := range testAccountEnv(keys) {
+			container = container.WithEnvVariable(envVar.name, envVar.value)
+		}
+	}
+	if opts.installPrefix != "" {
+		container = container.
+			WithEnvVariable("CARGO_INSTALL_ROOT", opts.installPrefix).
+			WithEnvVariable("PATH", opts.installPrefix+"/bin:$PATH", dagger.ContainerWithEnvVariableOpts{Expand: true})
+	}
+	for _, envVar := range opts.env {
+		container = container.WithEnvVariable(envVar.name, envVar.value)
+	}
+	container = container.
+		WithExec([]string{
+			"sh", "-c",
+			buildCommand(opts),
+		})
+	if opts.fixtures != nil {
+		// Mounted after the build so that changing the fixtures doesn't invalidate it
+		container = container.
+			WithMountedDirectory(testFixturesDir, opts.fixtures).
+			WithEnvVariable("RECALL_TEST_FIXTURES", testFixturesDir)
+	}
+	return container, nil
+}
 
-	_, testAccountPrivateKey := m.getRandomTestAccount()
+// testFixturesDir is where the fixtures passed to Test are mounted, and what RECALL_TEST_FIXTURES points the tests at.
+const testFixturesDir = "/src/test-fixtures"
 
-	return containerWithAuth.
-		From("rust:slim-bookworm").
-		WithExec([]string{
-			"apt-get", "update",
-		}).
+// buildCommand returns the shell command that codeContainer builds and installs the CLI with, checking that it loads
+// the network config.
+func buildCommand(opts codeContainerOpts) string {
+	cmd := "make build install && "
+	if opts.installPrefix != "" {
+		cmd += installPrefixCheckCmd + " && "
+	}
+	cmd += installCheckCmd + " && " + networksTomlCheckCmd
+	if opts.sccache {
+		// The stats are kept by the sccache server started by the build, so they have to be shown in the same exec
+		cmd += " && sccache --show-stats"
+	}
+	return cmd
+}
+
+// aptPackagePattern matches Debian package names. It also keeps apt options out of the install command.
+var aptPackagePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+
+// validateAptPackages checks that packages are all valid Debian package names.
+func validateAptPackages(packages []string) error {
+	for _, pkg := range packages {
+		if !aptPackagePattern.MatchString(pkg) {
+			return fmt.Errorf("invalid apt package name %q", pkg)
+		}
+	}
+	return nil
+}
+
+// rustToolchainPattern matches rustup toolchain names, e.g. stable, 1.80.0 or nightly-2024-06-01. It also keeps rustup
+// options out of its commands.
+var rustToolchainPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// rustupCommands returns the commands that install opts.rustToolchain, with the components the lint phase needs, and
+// make it the default, or none when no toolchain is given.
+func rustupCommands(opts codeContainerOpts) [][]string {
+	if opts.rustToolchain == "" {
+		return nil
+	}
+	return [][]string{
+		{"rustup", "toolchain", "install", opts.rustToolchain, "--component", "clippy", "--component", "rustfmt"},
+		{"rustup", "default", opts.rustToolchain},
+	}
+}
+
+// rustImageRef returns the base image of the Rust containers.
+func rustImageRef(opts codeContainerOpts) string {
+	if opts.rustImage == "" {
+		return "rust:slim-bookworm"
+	}
+	return opts.rustImage
+}
+
+// rustAptPackages returns the system packages that the Rust containers of opts are set up with.
+func rustAptPackages(opts codeContainerOpts) []string {
+	packages := aptPackages
+	if opts.lightweight {
+		packages = lightweightAptPackages
+	}
+	return append(slices.Clone(packages), opts.extraPackages...)
+}
+
+// rustCargoTools returns the cargo tools that the Rust containers of opts install.
+func rustCargoTools(opts codeContainerOpts) []string {
+	tools := slices.Clone(opts.cargoTools)
+	if opts.sccache {
+		tools = append(tools, "sccache")
+	}
+	return tools
+}
+
+// buildContainer returns a container with the sources mounted and `make build` executed. Unlike codeContainer, it is
+// not configured for localnet.
+func (m *Ci) buildContainer(
+	ctx context.Context,
+	containerWithAuth *dagger.Container,
+	source *dagger.Directory,
+	opts codeContainerOpts,
+) (*dagger.Container, error) {
+	opts, err := keyTargetCache(ctx, source, opts)
+	if err != nil {
+		return nil, err
+	}
+	return m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec([]string{"sh", "-c", "make build"}), nil
+}
+
+// aptPackages are the system packages that every Rust container is set up with, before any extra ones.
+var aptPackages = []string{"make", "build-essential", "pkg-config", "libssl-dev", "git", "jq", "bc", "curl"}
+
+// lightweightAptPackages are the system packages that checking the code without building or testing it needs: make for
+// the make targets, git for the git dependencies, and pkg-config and libssl-dev for the openssl-sys build script that
+// clippy and rustdoc run. The C compiler of the other build scripts comes with the Rust image.
+var lightweightAptPackages = []string{"make", "pkg-config", "libssl-dev", "git"}
+
+// baseContainer returns the Rust image with the system packages installed. It only depends on the image, the proxy
+// and the package list, so the apt layer is reused by every run that shares those, whatever the toolchain, caches or
+// sources. The apt package lists and archives are kept in cache volumes, so that when the layer does have to be
+// rebuilt, e.g. because the image tag moved, only what changed upstream is downloaded again. They are locked while
+// apt runs, since apt can't share them, and unmounted afterwards so that the execs on top don't hold the lock.
+func (m *Ci) baseContainer(containerWithAuth *dagger.Container, opts codeContainerOpts) *dagger.Container {
+	container := containerWithAuth.From(rustImageRef(opts))
+	if opts.httpsProxy != "" {
+		// Set before anything is downloaded, so that the toolchain, apt and cargo downloads all go through it
+		for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY", "https_proxy", "http_proxy"} {
+			container = container.WithEnvVariable(name, opts.httpsProxy)
+		}
+	}
+	locked := dagger.ContainerWithMountedCacheOpts{Sharing: dagger.CacheSharingModeLocked}
+	return container.
+		WithMountedCache("/var/cache/apt", dag.CacheVolume("apt-archives"), locked).
+		WithMountedCache("/var/lib/apt/lists", dag.CacheVolume("apt-lists"), locked).
 		WithExec([]string{
-			"apt-get", "install", "-y",
-			"make",
-			"build-essential",
-			"pkg-config",
-			"libssl-dev",
-			"git",
-			"jq",
-			"bc",
+			"sh", "-c",
+			// The image deletes downloaded packages after every install, which would leave nothing in the cache
+			"rm -f /etc/apt/apt.conf.d/docker-clean && apt-get update && apt-get install -y " +
+				strings.Join(rustAptPackages(opts), " "),
 		}).
+		WithoutMount("/var/cache/apt").
+		WithoutMount("/var/lib/apt/lists")
+}
+
+// rustContainer returns a container with the Rust toolchain, system packages and cargo caches set up, without any
+// sources mounted.
+func (m *Ci) rustContainer(containerWithAuth *dagger.Container, opts codeContainerOpts) *dagger.Container {
+	// Create Rust-specific caches
+	featureFlags := cargoFeatureFlags(opts)
+	cargoRegistryKey := opts.cacheName("cargo-registry")
+	cargoGitKey := opts.cacheName("cargo-git")
+	rustupCacheKey := opts.cacheName("rustup-cache")
+	cargoTargetKey := opts.cargoTargetKey()
+	cargoRegistry := dag.CacheVolume(cargoRegistryKey)
+	cargoGit := dag.CacheVolume(cargoGitKey)
+	cargoTarget := dag.CacheVolume(cargoTargetKey)
+	rustupCache := dag.CacheVolume(rustupCacheKey)
+	debugf("using cache volumes %s, %s, %s and %s", cargoRegistryKey, cargoGitKey, rustupCacheKey, cargoTargetKey)
+
+	container := m.baseContainer(containerWithAuth, opts)
+	for _, cmd := range rustupCommands(opts) {
+		container = container.WithExec(cmd)
+	}
+	if opts.rustToolchain != "" {
+		// rust-toolchain.toml in the sources takes precedence over the default toolchain, but not over this
+		container = container.WithEnvVariable("RUSTUP_TOOLCHAIN", opts.rustToolchain)
+	}
+
+	container = container.
 		// Rust caches and env vars
 		WithMountedCache("/root/.cargo/registry", cargoRegistry).
 		WithMountedCache("/root/.cargo/git", cargoGit).
@@ -134,85 +1685,105 @@ func (m *Ci) codeContainer(
 		WithMountedCache("/src/target", cargoTarget).
 		WithEnvVariable("CARGO_INCREMENTAL", "1").
 		WithEnvVariable("CARGO_NET_RETRY", "10").
-		WithEnvVariable("CARGO_NET_GIT_FETCH_WITH_CLI", "true").
-		// Create the config directory and file
-		WithExec([]string{
-			"mkdir", "-p", "/root/.config/recall",
-		}).
-		WithExec([]string{
-			"sh", "-c",
-			"cat > /root/.config/recall/networks.toml << 'EOL'\n" + networksTomlContent + "\nEOL",
-		}).
-		WithDirectory("/src", source).
-		WithWorkdir("/src").
-		WithEnvVariable("RECALL_NETWORK_CONFIG_FILE", "/root/.config/recall/networks.toml").
-		WithEnvVariable("RECALL_NETWORK", "localnet").
-		WithEnvVariable("RECALL_PRIVATE_KEY", testAccountPrivateKey).
-		WithExec([]string{
-			"sh", "-c",
-			"make build install",
-		}), nil
+		WithEnvVariable("CARGO_NET_GIT_FETCH_WITH_CLI", "true")
+	if opts.cratesMirror != "" {
+		container = container.WithNewFile("/root/.cargo/config.toml", cratesMirrorConfig(opts.cratesMirror))
+	}
+	if opts.rustFlags != "" {
+		container = container.WithEnvVariable("RUSTFLAGS", opts.rustFlags)
+	}
+	if featureFlags != "" {
+		// Picked up by the make targets that run cargo
+		container = container.WithEnvVariable("CARGO_FEATURE_FLAGS", featureFlags)
+	}
+	if opts.profile != "" {
+		container = container.WithEnvVariable("CARGO_PROFILE", opts.profile)
+	}
+	cargoTools := rustCargoTools(opts)
+	// Install cargo tools before mounting the sources so that they are cached independently of source changes
+	if len(cargoTools) > 0 {
+		container = container.WithExec(append([]string{"cargo", "install", "--locked"}, cargoTools...))
+	}
+	if opts.sccache {
+		container = container.
+			WithMountedCache("/root/.cache/sccache", dag.CacheVolume(opts.cacheName("sccache"))).
+			WithEnvVariable("SCCACHE_DIR", "/root/.cache/sccache").
+			WithEnvVariable("RUSTC_WRAPPER", "sccache").
+			// sccache can't cache incremental compilation
+			WithEnvVariable("CARGO_INCREMENTAL", "0")
+	}
+	if auth, ok := newGitTokenAuth(opts.gitToken); ok {
+		container = container.
+			WithSecretVariable(auth.secretEnv, auth.token).
+			WithExec(auth.configCmd)
+	}
+	return container
+}
+
+// gitTokenAuth is how the code container hands a git token to git: the token is set as a secret variable, and the
+// credential helper in the git config refers to it by name. The config layer so holds no secret, and the token itself
+// is only ever in the environment, which Dagger scrubs from the logs.
+type gitTokenAuth struct {
+	secretEnv string
+	token     *dagger.Secret
+	configCmd []string
 }
 
-func (m *Ci) localnetService(localnetContainer *dagger.Container) *dagger.Service {
+// newGitTokenAuth returns the git auth for token, or false when there is no token.
+func newGitTokenAuth(token *dagger.Secret) (gitTokenAuth, bool) {
+	if token == nil {
+		return gitTokenAuth{}, false
+	}
+	return gitTokenAuth{
+		secretEnv: "GIT_TOKEN",
+		token:     token,
+		configCmd: []string{
+			"git", "config", "--global", "credential.helper",
+			`!f() { echo username=x-access-token; echo "password=$GIT_TOKEN"; }; f`,
+		},
+	}, true
+}
+
+// localnetCommand returns the command that applies limits and runs cmd with its output also written to logFile. The
+// image's sh may have no pipefail, so the exit status of cmd is passed around tee through a file, and logged too, so
+// that localnet crashing shows up as the service failing and in the logs rather than as tee's success.
+func localnetCommand(limits localnetLimits, logFile string, cmd []string) []string {
+	script := limits.script() +
+		`{ "$@" 2>&1; status=$?; echo "localnet exited with status $status"; echo "$status" > "$0.status"; } | ` +
+		`tee "$0"` + "\n" +
+		`exit "$(cat "$0.status")"`
+	return append([]string{"sh", "-c", script, logFile}, cmd...)
+}
+
+func (m *Ci) localnetService(
+	ctx context.Context,
+	localnetContainer *dagger.Container,
+	ports []localnetPort,
+	logFile string,
+	limits localnetLimits,
+) (*dagger.Service, error) {
+	for _, port := range ports {
+		localnetContainer = localnetContainer.WithExposedPort(port.port, dagger.ContainerWithExposedPortOpts{
+			ExperimentalSkipHealthcheck: !port.healthcheck,
+		})
+	}
+	// Run the image's own command with its output also written to logFile, so that it can be read back on failure
+	entrypoint, err := localnetContainer.Entrypoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defaultArgs, err := localnetContainer.DefaultArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
 	return localnetContainer.
-		WithExposedPort(8545).
-		WithExposedPort(8645).
-		WithExposedPort(26657).
+		WithMountedCache(localnetLogDir, localnetLogCache).
 		AsService(
 			dagger.ContainerAsServiceOpts{
+				Args:                     localnetCommand(limits, logFile, append(entrypoint, defaultArgs...)),
 				InsecureRootCapabilities: true,
 				NoInit:                   true,
-				UseEntrypoint:            true,
 			},
 		).
-		WithHostname("localnet")
-}
-
-func (m *Ci) getRandomTestAccount() (string, string) {
-	type testAccount struct {
-		address    string
-		privateKey string
-	}
-	// The first two Anvil test accounts are intentionally excluded since they are used to submit validator IPC
-	// transactions in the 2-node localnet setup used for testing. Using those accounts in tests can lead to nonce
-	// clashing issues and cause unexpected failures.
-	defaultTestAccounts := []testAccount{
-		{
-			address:    "0x3C44CdDdB6a900fa2b585dd299e03d12FA4293BC",
-			privateKey: "0x5de4111afa1a4b94908f83103eb1f1706367c2e68ca870fc3fb9a804cdab365a",
-		},
-		{
-			address:    "0x90F79bf6EB2c4f870365E785982E1f101E93b906",
-			privateKey: "0x7c852118294e51e653712a81e05800f419141751be58f605c371e15141b007a6",
-		},
-		{
-			address:    "0x15d34AAf54267DB7D7c367839AAf71A00a2C6A65",
-			privateKey: "0x47e179ec197488593b187f80a00eb0da91f1b9d0b13f8733639f19c30a34926a",
-		},
-		{
-			address:    "0x9965507D1a55bcC2695C58ba16FB37d819B0A4dc",
-			privateKey: "0x8b3a350cf5c34c9194ca85829a2df0ec3153be0318b5e2d3348e872092edffba",
-		},
-		{
-			address:    "0x976EA74026E726554dB657fA54763abd0C3a0aa9",
-			privateKey: "0x92db14e403b83dfe3df233f83dfa3a0d7096f21ca9b0d6d6b8d88b2b4ec1564e",
-		},
-		{
-			address:    "0x14dC79964da2C08b23698B3D3cc7Ca32193d9955",
-			privateKey: "0x4bbbf85ce3377467afe5d46f804f221813b2bb87f24d81f60f1fcdbf7cbf4356",
-		},
-		{
-			address:    "0x23618e81E3f5cdF7f54C3d65f7FBc0aBf5B21E8f",
-			privateKey: "0xdbda1821b80551c9d65939329250298aa3472ba22feea921c0cf5d620ea67b97",
-		},
-		{
-			address:    "0xa0Ee7A142d267C1f36714E4a8F75612F20a79720",
-			privateKey: "0x2a871d0798f97d79848a013d4936a73bf4cc922c825d33c1cf7073dff6d409c6",
-		},
-	}
-
-	randomIndex := rand.Intn(len(defaultTestAccounts))
-	randomAccount := defaultTestAccounts[randomIndex]
-	return randomAccount.address, randomAccount.privateKey
+		WithHostname("localnet"), nil
 }
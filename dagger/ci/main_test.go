@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"dagger/ci/internal/dagger"
+)
+
+func TestRustImageRef(t *testing.T) {
+	tests := []struct {
+		name string
+		opts codeContainerOpts
+		want string
+	}{
+		{"default", codeContainerOpts{}, "rust:slim-bookworm"},
+		{"override", codeContainerOpts{rustImage: "rust:1.80-bookworm"}, "rust:1.80-bookworm"},
+		{"mirror", codeContainerOpts{rustImage: "mirror.example.com/library/rust@sha256:abc"},
+			"mirror.example.com/library/rust@sha256:abc"},
+		{"toolchain doesn't change the image", codeContainerOpts{rustToolchain: "1.80"}, "rust:slim-bookworm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rustImageRef(tt.opts); got != tt.want {
+				t.Errorf("rustImageRef() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRustAptPackages(t *testing.T) {
+	tests := []struct {
+		name string
+		opts codeContainerOpts
+		want []string
+	}{
+		{"default", codeContainerOpts{}, aptPackages},
+		{"lightweight", codeContainerOpts{lightweight: true}, lightweightAptPackages},
+		{"extra packages", codeContainerOpts{extraPackages: []string{"protobuf-compiler"}},
+			append(slices.Clone(aptPackages), "protobuf-compiler")},
+		{"lightweight with extra packages", codeContainerOpts{lightweight: true, extraPackages: []string{"clang"}},
+			append(slices.Clone(lightweightAptPackages), "clang")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rustAptPackages(tt.opts); !slices.Equal(got, tt.want) {
+				t.Errorf("rustAptPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRustAptPackagesDoesNotModifyDefaults(t *testing.T) {
+	defaults := slices.Clone(aptPackages)
+	rustAptPackages(codeContainerOpts{extraPackages: []string{"clang"}})
+	if !slices.Equal(aptPackages, defaults) {
+		t.Errorf("aptPackages changed to %v", aptPackages)
+	}
+}
+
+func TestRustCargoTools(t *testing.T) {
+	tests := []struct {
+		name string
+		opts codeContainerOpts
+		want []string
+	}{
+		{"none", codeContainerOpts{}, nil},
+		{"tools", codeContainerOpts{cargoTools: []string{"cargo-nextest"}}, []string{"cargo-nextest"}},
+		{"sccache", codeContainerOpts{sccache: true}, []string{"sccache"}},
+		{"tools and sccache", codeContainerOpts{cargoTools: []string{"cargo-hack"}, sccache: true},
+			[]string{"cargo-hack", "sccache"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rustCargoTools(tt.opts); !slices.Equal(got, tt.want) {
+				t.Errorf("rustCargoTools() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRustCargoToolsDoesNotModifyOpts(t *testing.T) {
+	tools := make([]string, 1, 2)
+	tools[0] = "cargo-nextest"
+	opts := codeContainerOpts{cargoTools: tools, sccache: true}
+	rustCargoTools(opts)
+	if got := opts.cargoTools[:cap(opts.cargoTools)]; got[1] != "" {
+		t.Errorf("rustCargoTools() wrote %q into the spare capacity of opts.cargoTools", got[1])
+	}
+}
+
+func TestCargoTargetKey(t *testing.T) {
+	const lockfile = "version = 3\n\n[[package]]\nname = \"recall_sdk\"\nversion = \"0.1.0\"\n"
+	newOpts := func() codeContainerOpts {
+		return codeContainerOpts{rustToolchain: "1.80", rustFlags: "-C debuginfo=1", features: []string{"foo"}}
+	}
+	base := newOpts()
+	key := func(lockfile string, opts codeContainerOpts) string {
+		return opts.withLockfile(lockfile).cargoTargetKey()
+	}
+
+	if a, b := key(lockfile, base), key(lockfile, newOpts()); a != b {
+		t.Errorf("the same lockfile and opts gave different keys %q and %q", a, b)
+	}
+
+	tests := []struct {
+		name     string
+		lockfile string
+		change   func(opts *codeContainerOpts)
+	}{
+		{"lockfile", lockfile + "\n[[package]]\nname = \"serde\"\nversion = \"1.0.0\"\n", nil},
+		{"toolchain", lockfile, func(opts *codeContainerOpts) { opts.rustToolchain = "1.81" }},
+		{"no toolchain", lockfile, func(opts *codeContainerOpts) { opts.rustToolchain = "" }},
+		{"rustflags", lockfile, func(opts *codeContainerOpts) { opts.rustFlags = "-C target-cpu=native" }},
+		{"no rustflags", lockfile, func(opts *codeContainerOpts) { opts.rustFlags = "" }},
+		{"features", lockfile, func(opts *codeContainerOpts) { opts.features = []string{"bar"} }},
+		{"no default features", lockfile, func(opts *codeContainerOpts) { opts.noDefaultFeatures = true }},
+		{"profile", lockfile, func(opts *codeContainerOpts) { opts.profile = "dev" }},
+		{"platform", lockfile, func(opts *codeContainerOpts) { opts.platform = "linux/arm64" }},
+		{"cpu features", lockfile, func(opts *codeContainerOpts) { opts.cpuFeaturesHash = "0123abcd" }},
+		{"cache namespace", lockfile, func(opts *codeContainerOpts) { opts.cacheNamespace = "main" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := base
+			if tt.change != nil {
+				tt.change(&changed)
+			}
+			if a, b := key(lockfile, base), key(tt.lockfile, changed); a == b {
+				t.Errorf("changing the %s kept the key %q", tt.name, a)
+			}
+		})
+	}
+}
+
+func TestCargoTargetKeyReleaseProfile(t *testing.T) {
+	// release is the profile used when none is given, so both share their artifacts
+	if a, b := (codeContainerOpts{}).cargoTargetKey(), (codeContainerOpts{profile: "release"}).cargoTargetKey(); a != b {
+		t.Errorf("the default and release profiles gave different keys %q and %q", a, b)
+	}
+}
+
+func TestNewGitTokenAuth(t *testing.T) {
+	if _, ok := newGitTokenAuth(nil); ok {
+		t.Errorf("newGitTokenAuth(nil) wired git auth without a token")
+	}
+
+	token := &dagger.Secret{}
+	auth, ok := newGitTokenAuth(token)
+	if !ok {
+		t.Fatalf("newGitTokenAuth() didn't wire git auth for a token")
+	}
+	if auth.token != token {
+		t.Errorf("newGitTokenAuth() set a different secret than the token")
+	}
+	if auth.secretEnv != "GIT_TOKEN" {
+		t.Errorf("newGitTokenAuth() set the token as %q, want GIT_TOKEN", auth.secretEnv)
+	}
+	wantCmd := []string{"git", "config", "--global", "credential.helper"}
+	if len(auth.configCmd) != len(wantCmd)+1 || !slices.Equal(auth.configCmd[:len(wantCmd)], wantCmd) {
+		t.Fatalf("newGitTokenAuth() config command = %q, want %q followed by the helper", auth.configCmd, wantCmd)
+	}
+	// The helper must read the token from the secret variable when git runs, not hold it in the command
+	helper := auth.configCmd[len(wantCmd)]
+	if !strings.Contains(helper, `"password=$`+auth.secretEnv+`"`) {
+		t.Errorf("credential helper %q doesn't read the password from $%s", helper, auth.secretEnv)
+	}
+	if strings.Count(helper, "password=") != 1 {
+		t.Errorf("credential helper %q sets the password more than once", helper)
+	}
+}
+
+// repoRoot is the root of the repository, whose Makefile and scripts the code container runs.
+const repoRoot = "../.."
+
+func TestMakefileTestFilter(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make isn't installed")
+	}
+	// Test sets TEST_FILTER in the environment of the code container, not on the make command line
+	for _, target := range []string{"test", "test-nextest", "run-sdk-tests", "run-sdk-tests-nextest", "run-all-tests"} {
+		t.Run(target, func(t *testing.T) {
+			cmd := exec.Command("make", "--no-print-directory", "-n", "-C", repoRoot, target)
+			cmd.Env = append(os.Environ(), "TEST_FILTER=bucket::can_add")
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("make -n %s: %v", target, err)
+			}
+			if got := strings.TrimSpace(string(out)); !strings.HasSuffix(got, " bucket::can_add") {
+				t.Errorf("make -n %s = %q, want the cargo command to end with the test filter", target, got)
+			}
+		})
+	}
+	t.Run("run-cli-tests", func(t *testing.T) {
+		cmd := exec.Command("make", "--no-print-directory", "-n", "-C", repoRoot, "run-cli-tests")
+		cmd.Env = append(os.Environ(), "TEST_FILTER=bucket")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("make -n run-cli-tests: %v", err)
+		}
+		if !strings.Contains(string(out), "TEST_FILTER=bucket ") {
+			t.Errorf("make -n run-cli-tests doesn't pass TEST_FILTER to the script:\n%s", out)
+		}
+	})
+}
+
+func TestRunCliTestsFilter(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash isn't installed")
+	}
+	script, err := filepath.Abs(filepath.Join(repoRoot, "scripts", "run-cli-tests.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		filter string
+		want   []string
+	}{
+		{"no filter", "", []string{"account.sh", "bucket.sh", "bucket_query.sh"}},
+		{"filter", "bucket", []string{"bucket.sh", "bucket_query.sh"}},
+		{"no match", "timehub", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The script runs every file in tests/cli under the working directory, so fake ones that log their name
+			dir := t.TempDir()
+			log := filepath.Join(dir, "ran.log")
+			if err := os.MkdirAll(filepath.Join(dir, "tests", "cli"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"account.sh", "bucket.sh", "bucket_query.sh"} {
+				test := "#!/bin/sh\necho " + name + " >> " + log + "\n"
+				if err := os.WriteFile(filepath.Join(dir, "tests", "cli", name), []byte(test), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmd := exec.Command("bash", script)
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(), "TEST_FILTER="+tt.filter)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("run-cli-tests.sh: %v\n%s", err, out)
+			}
+			ran, err := os.ReadFile(log)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if got := strings.Fields(string(ran)); !slices.Equal(got, tt.want) {
+				t.Errorf("run-cli-tests.sh with TEST_FILTER=%q ran %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalnetCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh isn't installed")
+	}
+	tests := []struct {
+		name       string
+		cmd        string
+		wantStatus int
+	}{
+		{"success", "echo started", 0},
+		{"crash", "echo started; echo panic >&2; exit 3", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "localnet.log")
+			args := localnetCommand(localnetLimits{}, logFile, []string{"sh", "-c", tt.cmd})
+			out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+			status := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				status = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("localnet command exited with %d, want %d\n%s", status, tt.wantStatus, out)
+			}
+			log, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(log), "started\n") || !strings.HasPrefix(string(out), "started\n") {
+				t.Errorf("localnet output isn't in both the log file and stdout\nlog: %q\nout: %q", log, out)
+			}
+			want := fmt.Sprintf("localnet exited with status %d\n", tt.wantStatus)
+			if !strings.HasSuffix(string(log), want) {
+				t.Errorf("log file %q doesn't end with %q", log, want)
+			}
+		})
+	}
+}
+
+func TestRustupCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		opts codeContainerOpts
+		want [][]string
+	}{
+		{"no toolchain", codeContainerOpts{}, nil},
+		{"toolchain", codeContainerOpts{rustToolchain: "1.80.0"}, [][]string{
+			{"rustup", "toolchain", "install", "1.80.0", "--component", "clippy", "--component", "rustfmt"},
+			{"rustup", "default", "1.80.0"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rustupCommands(tt.opts)
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("rustupCommands() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRustToolchainPattern(t *testing.T) {
+	for _, toolchain := range []string{"stable", "beta", "nightly", "1.80", "1.80.0", "nightly-2024-06-01",
+		"stable-x86_64-unknown-linux-gnu"} {
+		if !rustToolchainPattern.MatchString(toolchain) {
+			t.Errorf("rustToolchainPattern rejects %q", toolchain)
+		}
+	}
+	for _, toolchain := range []string{"", "-v", "--default-toolchain", "stable; curl x | sh", "stable && id",
+		"$(id)", "`id`", "stable\nid", "stable id", "../stable"} {
+		if rustToolchainPattern.MatchString(toolchain) {
+			t.Errorf("rustToolchainPattern accepts %q", toolchain)
+		}
+	}
+}
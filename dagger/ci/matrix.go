@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultPlatforms are built by BuildMatrix when no platforms are given.
+var defaultPlatforms = []string{"linux/amd64", "linux/arm64"}
+
+// matrixManifestEntry describes one binary built by BuildMatrix.
+type matrixManifestEntry struct {
+	Platform string `json:"platform"`
+	Path     string `json:"path"`
+	Size     int    `json:"size"`
+	Sha256   string `json:"sha256"`
+}
+
+// BuildMatrix builds the `recall` binary for each of the given platforms, defaulting to linux/amd64 and linux/arm64.
+// Each binary is built natively in a container for its platform, emulated where it doesn't match the engine's. The
+// returned directory has a subdirectory per platform (e.g. linux-arm64/recall) and a manifest.json listing each
+// binary's platform, size and sha256, along with a SHA256SUMS file that is signed when signingKey is given. Up to
+// maxParallel platforms are built at once, the rest wait for a free slot.
+func (m *Ci) BuildMatrix(
+	ctx context.Context,
+	// +optional
+	platforms []string,
+	// How many platforms to build at once, defaults to the number of CPUs
+	// +optional
+	maxParallel int,
+	// Armored gpg private key to sign SHA256SUMS with, into SHA256SUMS.asc
+	// +optional
+	signingKey *dagger.Secret,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	if len(platforms) == 0 {
+		platforms = defaultPlatforms
+	}
+	if maxParallel <= 0 {
+		maxParallel = runtime.NumCPU()
+	}
+	source = filterSource(source)
+
+	start := time.Now()
+	binaries := make([]*dagger.File, len(platforms))
+	manifest := make([]matrixManifestEntry, len(platforms))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxParallel)
+	for i, platform := range platforms {
+		g.Go(func() error {
+			containerWithAuth, err := m.getContainerWithAuth(dagger.Platform(platform), "", dockerUsername, dockerPassword)
+			if err != nil {
+				return err
+			}
+			container, err := m.buildContainer(
+				gctx, containerWithAuth, source, codeContainerOpts{platform: dagger.Platform(platform)},
+			)
+			if err != nil {
+				return err
+			}
+			binary := extractBinary(container, "/src/target/release/recall")
+
+			checksum, err := container.
+				WithFile("/tmp/recall", binary).
+				WithExec([]string{"sh", "-c", "sha256sum /tmp/recall | cut -d ' ' -f 1"}).
+				Stdout(gctx)
+			if err != nil {
+				return err
+			}
+			size, err := binary.Size(gctx)
+			if err != nil {
+				return err
+			}
+
+			binaries[i] = binary
+			manifest[i] = matrixManifestEntry{
+				Platform: platform,
+				Path:     platformName(dagger.Platform(platform)) + "/recall",
+				Size:     size,
+				Sha256:   strings.TrimSpace(checksum),
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	infof("built %d platforms, %d at a time, in %s", len(platforms), min(maxParallel, len(platforms)),
+		time.Since(start).Round(time.Millisecond))
+
+	output := dag.Directory()
+	for i, entry := range manifest {
+		output = output.WithFile(entry.Path, binaries[i])
+	}
+	manifestJson, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+	output = output.WithNewFile("manifest.json", string(manifestJson)+"\n")
+	return signArtifacts(ctx, containerWithAuth, output, signingKey)
+}
+
+// platformName turns a platform such as linux/arm64 into a name usable in paths and cache keys, e.g. linux-arm64.
+func platformName(platform dagger.Platform) string {
+	return strings.ReplaceAll(string(platform), "/", "-")
+}
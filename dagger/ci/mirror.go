@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// validateCargoNetwork checks the crates.io mirror and proxy that cargo is configured with, either of which may be
+// empty.
+func validateCargoNetwork(cratesMirror, httpsProxy string) error {
+	if cratesMirror != "" {
+		if err := validateCratesMirror(cratesMirror); err != nil {
+			return err
+		}
+	}
+	if httpsProxy != "" {
+		return validateProxy(httpsProxy)
+	}
+	return nil
+}
+
+// validateCratesMirror checks that mirror is the http or https URL of a registry index, optionally prefixed with
+// sparse+ for a sparse index.
+func validateCratesMirror(mirror string) error {
+	if !isHTTPURL(strings.TrimPrefix(mirror, "sparse+")) {
+		return fmt.Errorf("invalid crates.io mirror %q, expected an http or https URL, optionally prefixed with sparse+",
+			mirror)
+	}
+	return nil
+}
+
+// validateProxy checks that proxy is an http or https URL.
+func validateProxy(proxy string) error {
+	if !isHTTPURL(proxy) {
+		return fmt.Errorf("invalid proxy %q, expected an http or https URL", proxy)
+	}
+	return nil
+}
+
+// isHTTPURL reports whether s is an absolute http or https URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// cratesMirrorConfig returns the cargo config that replaces crates.io with the registry index at mirror.
+func cratesMirrorConfig(mirror string) string {
+	return fmt.Sprintf("[source.crates-io]\nreplace-with = \"mirror\"\n\n[source.mirror]\nregistry = %q\n", mirror)
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestIsHTTPURL(t *testing.T) {
+	for _, s := range []string{"http://localhost:4318", "https://s3.example.com/releases", "https://host/path?q=1",
+		"http://[::1]:8080"} {
+		if !isHTTPURL(s) {
+			t.Errorf("isHTTPURL(%q) = false, want true", s)
+		}
+	}
+	for _, s := range []string{"", "localhost:4318", "s3.example.com/releases", "ftp://host", "file:///tmp/x",
+		"https://", "https:///path", "sparse+https://index.crates.io/", "http//host", " https://host"} {
+		if isHTTPURL(s) {
+			t.Errorf("isHTTPURL(%q) = true, want false", s)
+		}
+	}
+}
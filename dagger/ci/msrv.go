@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+	"github.com/BurntSushi/toml"
+)
+
+// rustVersionPattern matches the versions that CheckMsrv accepts, which are those allowed in `rust-version`.
+var rustVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+(\.[0-9]+)?$`)
+
+// requiresNewerRustcPattern matches cargo's error for a dependency whose rust-version is newer than the active
+// toolchain, with the package as the submatch.
+var requiresNewerRustcPattern = regexp.MustCompile("(?m)^error: package `([^`]+)` cannot be built because it " +
+	"requires rustc [^ ]+ or newer")
+
+// CheckMsrv checks that the workspace compiles with its minimum supported Rust version, which defaults to the
+// rust-version in the workspace Cargo.toml. If it doesn't, the error names the packages that need a newer compiler or
+// failed to compile.
+func (m *Ci) CheckMsrv(
+	ctx context.Context,
+	// Rust version to check with, e.g. 1.80, defaults to the workspace's rust-version
+	// +optional
+	version string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if version == "" {
+		manifest, err := source.File("Cargo.toml").Contents(ctx)
+		if err != nil {
+			return "", fmt.Errorf("read Cargo.toml: %w", err)
+		}
+		version, err = workspaceRustVersion(manifest)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !rustVersionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid Rust version %q, expected e.g. 1.80 or 1.80.1", version)
+	}
+
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	source = filterSource(source)
+	// Artifacts of another compiler can't be reused, so the MSRV builds get their own caches
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{
+		rustToolchain:  version,
+		cacheNamespace: "msrv-" + version,
+	})
+	if err != nil {
+		return "", err
+	}
+	check := m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec(
+			[]string{"sh", "-c", "cargo check --locked --workspace 2>&1"},
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+		)
+	exitCode, err := check.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	output, err := check.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", msrvError(version, output)
+	}
+	return "workspace compiles with Rust " + version, nil
+}
+
+// workspaceRustVersion returns the rust-version of the workspace, or of the root package if it isn't set for the
+// workspace.
+func workspaceRustVersion(manifest string) (string, error) {
+	var cargoToml struct {
+		Workspace struct {
+			Package struct {
+				RustVersion string `toml:"rust-version"`
+			} `toml:"package"`
+		} `toml:"workspace"`
+		Package struct {
+			RustVersion string `toml:"rust-version"`
+		} `toml:"package"`
+	}
+	if _, err := toml.Decode(manifest, &cargoToml); err != nil {
+		return "", fmt.Errorf("parse Cargo.toml: %w", err)
+	}
+	if version := cargoToml.Workspace.Package.RustVersion; version != "" {
+		return version, nil
+	}
+	if version := cargoToml.Package.RustVersion; version != "" {
+		return version, nil
+	}
+	return "", fmt.Errorf("Cargo.toml sets no rust-version, pass the version to check")
+}
+
+// msrvError describes a failed check with the given Rust version, naming the packages that require a newer compiler
+// and the crates that failed to compile.
+func msrvError(version, output string) error {
+	var reasons []string
+	for _, match := range requiresNewerRustcPattern.FindAllStringSubmatch(output, -1) {
+		reasons = append(reasons, match[1]+" requires a newer rustc")
+	}
+	for _, match := range cargoCompileErrorPattern.FindAllStringSubmatch(output, -1) {
+		reasons = append(reasons, match[1]+" failed to compile")
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("workspace does not compile with Rust %s:\n%s", version, output)
+	}
+	return fmt.Errorf(
+		"workspace does not compile with Rust %s: %s\n%s", version, strings.Join(reasons, ", "), output,
+	)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// networksTomlCheckCmd checks that the installed CLI can load $RECALL_NETWORK from the networks.toml written by
+// codeContainer, so that a config the CLI no longer parses fails right after the build with the CLI's own error
+// instead of as connection errors in the tests. `recall account create` resolves the network config like every
+// command, but only generates a key locally, so it doesn't need the network to be reachable.
+const networksTomlCheckCmd = `{ recall account create > /dev/null 2> /tmp/networks-check.log || ` +
+	`{ echo "recall could not load network $RECALL_NETWORK from $RECALL_NETWORK_CONFIG_FILE, ` +
+	`check that the generated networks.toml matches the schema the CLI expects:" >&2; ` +
+	`cat /tmp/networks-check.log >&2; exit 1; }; }`
+
+// NetworkConfig is a network entry of the networks.toml file read by the CLI and SDK tests. It isn't specific to
+// localnet: the external networks of Test are described by it too.
+type NetworkConfig struct {
+	ChainId            uint64
+	SubnetId           string
+	RpcUrl             string
+	ObjectApiUrl       string
+	EvmRpcUrl          string
+	EvmGatewayAddress  string
+	EvmRegistryAddress string
+
+	ParentEvmRpcUrl              string
+	ParentEvmGatewayAddress      string
+	ParentEvmRegistryAddress     string
+	ParentEvmSupplySourceAddress string
+}
+
+// localnetPorts overrides the ports of the localnet endpoints. Zero values keep the ports from the localnet image.
+type localnetPorts struct {
+	rpc          int
+	evmRpc       int
+	objectApi    int
+	parentEvmRpc int
+}
+
+// networksTomlEntry is a network of a networks.toml file, in the layout the CLI and SDK read it in.
+type networksTomlEntry struct {
+	SubnetConfig struct {
+		ChainId            uint64 `toml:"chain_id"`
+		SubnetId           string `toml:"subnet_id"`
+		RpcUrl             string `toml:"rpc_url"`
+		ObjectApiUrl       string `toml:"object_api_url"`
+		EvmRpcUrl          string `toml:"evm_rpc_url"`
+		EvmGatewayAddress  string `toml:"evm_gateway_address"`
+		EvmRegistryAddress string `toml:"evm_registry_address"`
+	} `toml:"subnet_config"`
+	ParentNetworkConfig struct {
+		EvmRpcUrl              string `toml:"evm_rpc_url"`
+		EvmGatewayAddress      string `toml:"evm_gateway_address"`
+		EvmRegistryAddress     string `toml:"evm_registry_address"`
+		EvmSupplySourceAddress string `toml:"evm_supply_source_address"`
+	} `toml:"parent_network_config"`
+}
+
+// parseNetworksToml reads the localnet network from the contents of a networks.toml file.
+func parseNetworksToml(content string) (NetworkConfig, error) {
+	var networks map[string]networksTomlEntry
+	if _, err := toml.Decode(content, &networks); err != nil {
+		return NetworkConfig{}, fmt.Errorf("invalid networks.toml: %w", err)
+	}
+	entry := networks["localnet"]
+	cfg := NetworkConfig{
+		ChainId:            entry.SubnetConfig.ChainId,
+		SubnetId:           entry.SubnetConfig.SubnetId,
+		RpcUrl:             entry.SubnetConfig.RpcUrl,
+		ObjectApiUrl:       entry.SubnetConfig.ObjectApiUrl,
+		EvmRpcUrl:          entry.SubnetConfig.EvmRpcUrl,
+		EvmGatewayAddress:  entry.SubnetConfig.EvmGatewayAddress,
+		EvmRegistryAddress: entry.SubnetConfig.EvmRegistryAddress,
+
+		ParentEvmRpcUrl:              entry.ParentNetworkConfig.EvmRpcUrl,
+		ParentEvmGatewayAddress:      entry.ParentNetworkConfig.EvmGatewayAddress,
+		ParentEvmRegistryAddress:     entry.ParentNetworkConfig.EvmRegistryAddress,
+		ParentEvmSupplySourceAddress: entry.ParentNetworkConfig.EvmSupplySourceAddress,
+	}
+	if cfg.SubnetId == "" || cfg.RpcUrl == "" || cfg.EvmRpcUrl == "" {
+		return cfg, fmt.Errorf("networks.toml has no complete localnet subnet config")
+	}
+	return cfg, nil
+}
+
+// networkRpcUrlEnv is the variable of the code container that holds the CometBFT RPC URL of the network the tests run
+// against, for the readiness checks, which can't parse networks.toml themselves.
+const networkRpcUrlEnv = "NETWORK_RPC_URL"
+
+// networkRpcUrlExpr is the shell expansion of networkRpcUrlEnv without a trailing slash, to append paths to.
+const networkRpcUrlExpr = "${" + networkRpcUrlEnv + "%/}"
+
+// networkRpcUrl returns the CometBFT RPC URL of network from the contents of a networks.toml file.
+func networkRpcUrl(content, network string) (string, error) {
+	var networks map[string]networksTomlEntry
+	if _, err := toml.Decode(content, &networks); err != nil {
+		return "", fmt.Errorf("invalid networks.toml: %w", err)
+	}
+	rpcUrl := networks[network].SubnetConfig.RpcUrl
+	if rpcUrl == "" {
+		return "", fmt.Errorf("networks.toml has no rpc_url in its [%s.subnet_config] section", network)
+	}
+	return rpcUrl, nil
+}
+
+// validateNetworksToml checks that content is a valid TOML networks file that configures the subnet of network.
+func validateNetworksToml(content, network string) error {
+	var networks map[string]struct {
+		SubnetConfig map[string]any `toml:"subnet_config"`
+	}
+	if _, err := toml.Decode(content, &networks); err != nil {
+		return fmt.Errorf("invalid networks.toml: %w", err)
+	}
+	if networks[network].SubnetConfig == nil {
+		return fmt.Errorf("networks.toml has no [%s.subnet_config] section", network)
+	}
+	return nil
+}
+
+// withHost points every URL in the config at host, applying any port overrides.
+func (cfg NetworkConfig) withHost(host string, ports localnetPorts) (NetworkConfig, error) {
+	for _, endpoint := range []struct {
+		url  *string
+		port int
+	}{
+		{&cfg.RpcUrl, ports.rpc},
+		{&cfg.ObjectApiUrl, ports.objectApi},
+		{&cfg.EvmRpcUrl, ports.evmRpc},
+		{&cfg.ParentEvmRpcUrl, ports.parentEvmRpc},
+	} {
+		if *endpoint.url == "" {
+			continue
+		}
+		u, err := url.Parse(*endpoint.url)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid localnet URL %q: %w", *endpoint.url, err)
+		}
+		port := u.Port()
+		if endpoint.port != 0 {
+			port = strconv.Itoa(endpoint.port)
+		}
+		u.Host = host
+		if port != "" {
+			u.Host += ":" + port
+		}
+		*endpoint.url = u.String()
+	}
+	return cfg, nil
+}
+
+// localnetPort is a port of the localnet service and whether Dagger should wait for it to listen before the service
+// counts as started.
+type localnetPort struct {
+	port        int
+	healthcheck bool
+}
+
+// ports returns the ports of the endpoints that localnet serves, in the order they come up. The parent EVM RPC is
+// served by Anvil, which localnet starts first and which listens within seconds, so it is healthchecked to catch a
+// service that doesn't start at all. The EVM RPC and CometBFT RPC only listen once the chain has booted, and the object
+// API later still, so waiting on them would hold up the service binding for as long as the boot takes; waitForLocalnet
+// checks that the chain is up instead.
+func (cfg NetworkConfig) ports() []localnetPort {
+	var ports []localnetPort
+	for _, endpoint := range []struct {
+		rawUrl      string
+		healthcheck bool
+	}{
+		{cfg.ParentEvmRpcUrl, true},
+		{cfg.EvmRpcUrl, false},
+		{cfg.RpcUrl, false},
+		{cfg.ObjectApiUrl, false},
+	} {
+		u, err := url.Parse(endpoint.rawUrl)
+		if err != nil {
+			continue
+		}
+		if port, err := strconv.Atoi(u.Port()); err == nil {
+			ports = append(ports, localnetPort{port: port, healthcheck: endpoint.healthcheck})
+		}
+	}
+	return ports
+}
+
+// renderNetworksToml renders the config as a networks.toml file with a single network called name, localnet or the
+// external network the tests run against.
+func renderNetworksToml(name string, cfg NetworkConfig) string {
+	var b strings.Builder
+	b.WriteString("[" + name + ".subnet_config]\n")
+	if cfg.ChainId != 0 {
+		fmt.Fprintf(&b, "chain_id = %d\n", cfg.ChainId)
+	}
+	writeTomlString(&b, "subnet_id", cfg.SubnetId)
+	writeTomlString(&b, "rpc_url", cfg.RpcUrl)
+	writeTomlString(&b, "object_api_url", cfg.ObjectApiUrl)
+	writeTomlString(&b, "evm_rpc_url", cfg.EvmRpcUrl)
+	writeTomlString(&b, "evm_gateway_address", cfg.EvmGatewayAddress)
+	writeTomlString(&b, "evm_registry_address", cfg.EvmRegistryAddress)
+
+	if cfg.ParentEvmRpcUrl != "" {
+		b.WriteString("\n[" + name + ".parent_network_config]\n")
+		writeTomlString(&b, "evm_rpc_url", cfg.ParentEvmRpcUrl)
+		writeTomlString(&b, "evm_gateway_address", cfg.ParentEvmGatewayAddress)
+		writeTomlString(&b, "evm_registry_address", cfg.ParentEvmRegistryAddress)
+		writeTomlString(&b, "evm_supply_source_address", cfg.ParentEvmSupplySourceAddress)
+	}
+	return b.String()
+}
+
+func writeTomlString(b *strings.Builder, key, value string) {
+	if value != "" {
+		fmt.Fprintf(b, "%s = %s\n", key, tomlQuote(value))
+	}
+}
+
+// tomlQuote returns value as a TOML basic string, which strconv.Quote can't produce: TOML has no \x, \a or \v escapes.
+func tomlQuote(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestRenderNetworksTomlRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  NetworkConfig
+	}{
+		{
+			name: "subnet only",
+			cfg: NetworkConfig{
+				ChainId:   248163216,
+				SubnetId:  "/r314159/t410f726d2jv6uj4mpkcbgg5ndlpp3l7dd5rlcpgzkoi",
+				RpcUrl:    "http://localnet:26657",
+				EvmRpcUrl: "http://localnet:8645",
+			},
+		},
+		{
+			name: "with parent network",
+			cfg: NetworkConfig{
+				ChainId:            248163216,
+				SubnetId:           "/r31337/t410f6gbdxrbehnaeeo4mrq7wc5hgq6smnefys4qanwi",
+				RpcUrl:             "http://localnet:26657",
+				ObjectApiUrl:       "http://localnet:8001",
+				EvmRpcUrl:          "http://localnet:8645",
+				EvmGatewayAddress:  "0x77aa40b105843728088c0132e43fc44348881da8",
+				EvmRegistryAddress: "0x74539671a1d2f1c8f200826baba665179f53a1b7",
+
+				ParentEvmRpcUrl:              "http://localnet:8545",
+				ParentEvmGatewayAddress:      "0x9a676e781a523b5d0c0e43731313a708cb607508",
+				ParentEvmRegistryAddress:     "0x322813fd9a801c5507c9de605d63cea4f2ce6c44",
+				ParentEvmSupplySourceAddress: "0x4a679253410272dd5232b3ff7cf5dbb88f295319",
+			},
+		},
+		{
+			name: "values that need quoting",
+			cfg: NetworkConfig{
+				SubnetId:     `/r314159/"quoted"\back\slash`,
+				RpcUrl:       "http://localnet:26657/?a=1&b=#frag",
+				ObjectApiUrl: "http://локалнет:8001/ünïcode",
+				EvmRpcUrl:    "http://localnet:8645/\ttab\nnewline\rreturn",
+				// Control characters that Go escapes as \a, \v and \x, which TOML doesn't have
+				EvmGatewayAddress: "bell\a vtab\v nul\x00 del\x7f",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := renderNetworksToml("localnet", tt.cfg)
+
+			var decoded map[string]networksTomlEntry
+			if _, err := toml.Decode(content, &decoded); err != nil {
+				t.Fatalf("rendered networks.toml isn't valid TOML: %v\n%s", err, content)
+			}
+			if err := validateNetworksToml(content, "localnet"); err != nil {
+				t.Fatalf("validateNetworksToml() = %v\n%s", err, content)
+			}
+			got, err := parseNetworksToml(content)
+			if err != nil {
+				t.Fatalf("parseNetworksToml() = %v\n%s", err, content)
+			}
+			if got != tt.cfg {
+				t.Errorf("round trip changed the config\ngot  %+v\nwant %+v\n%s", got, tt.cfg, content)
+			}
+		})
+	}
+}
+
+func TestRenderNetworksTomlName(t *testing.T) {
+	content := renderNetworksToml("testnet", NetworkConfig{SubnetId: "/r314159", RpcUrl: "http://rpc", EvmRpcUrl: "x"})
+	if err := validateNetworksToml(content, "testnet"); err != nil {
+		t.Errorf("validateNetworksToml(testnet) = %v", err)
+	}
+	if err := validateNetworksToml(content, "localnet"); err == nil {
+		t.Errorf("validateNetworksToml(localnet) = nil, want an error for the missing network")
+	}
+}
+
+func TestParseNetworksToml(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    NetworkConfig
+		wantErr bool
+	}{
+		{
+			name: "unquoted and literal values",
+			content: `
+# comments, literal strings and other networks are valid TOML too
+[testnet.subnet_config]
+chain_id = 2481632
+rpc_url = 'https://testnet'
+
+[localnet.subnet_config]
+chain_id = 248163216
+subnet_id = '/r31337/t410f'
+rpc_url = "http://localnet:26657"
+evm_rpc_url = """http://localnet:8645"""
+`,
+			want: NetworkConfig{
+				ChainId:   248163216,
+				SubnetId:  "/r31337/t410f",
+				RpcUrl:    "http://localnet:26657",
+				EvmRpcUrl: "http://localnet:8645",
+			},
+		},
+		{
+			name:    "invalid TOML",
+			content: "[localnet.subnet_config]\nrpc_url = \n",
+			wantErr: true,
+		},
+		{
+			name:    "incomplete localnet config",
+			content: "[localnet.subnet_config]\nrpc_url = \"http://localnet:26657\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "no localnet",
+			content: "[testnet.subnet_config]\nsubnet_id = \"/r314159\"\nrpc_url = \"x\"\nevm_rpc_url = \"y\"\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNetworksToml(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNetworksToml() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseNetworksToml() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkRpcUrl(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		network string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "double quoted",
+			content: "[localnet.subnet_config]\nrpc_url = \"http://localnet:26657\"\n",
+			network: "localnet",
+			want:    "http://localnet:26657",
+		},
+		{
+			name: "several networks",
+			content: `
+[testnet.subnet_config]
+rpc_url = "https://api.testnet.recall.network"
+
+[localnet.subnet_config]
+rpc_url = "http://localnet:26657"
+`,
+			network: "testnet",
+			want:    "https://api.testnet.recall.network",
+		},
+		{
+			name:    "single quotes, spacing and indentation",
+			content: "[devnet.subnet_config]\n  rpc_url='http://devnet:26657/'  # trailing comment\n",
+			network: "devnet",
+			want:    "http://devnet:26657/",
+		},
+		{
+			name:    "missing network",
+			content: "[localnet.subnet_config]\nrpc_url = \"http://localnet:26657\"\n",
+			network: "testnet",
+			wantErr: true,
+		},
+		{
+			name:    "missing rpc_url",
+			content: "[localnet.subnet_config]\nevm_rpc_url = \"http://localnet:8645\"\n",
+			network: "localnet",
+			wantErr: true,
+		},
+		{
+			name:    "invalid TOML",
+			content: "[localnet.subnet_config\n",
+			network: "localnet",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := networkRpcUrl(tt.content, tt.network)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("networkRpcUrl(%q) error = %v, wantErr %v", tt.network, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("networkRpcUrl(%q) = %q, want %q", tt.network, got, tt.want)
+			}
+		})
+	}
+}
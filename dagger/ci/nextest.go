@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Nextest runs all the workspace tests, including the SDK integration tests, with cargo-nextest against localnet and
+// returns nextest's summary. Each test runs in its own process, so flaky tests can be retried individually. A run with
+// failing tests returns an error with the summary.
+func (m *Ci) Nextest(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	// Number of times to retry a failing test, defaults to the ci nextest profile's setting
+	// +optional
+	retries int,
+	// Number of tests to run at once, defaults to the number of CPUs
+	// +optional
+	testThreads int,
+	source *dagger.Directory,
+) (string, error) {
+	cmd := "cargo nextest run --locked --workspace --profile ci --no-fail-fast"
+	if retries > 0 {
+		cmd += " --retries " + strconv.Itoa(retries)
+	}
+	if testThreads > 0 {
+		cmd += " --test-threads " + strconv.Itoa(testThreads)
+	}
+
+	codeContainer, localnet, err := m.localnetSetup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{},
+		codeContainerOpts{cargoTools: []string{"cargo-nextest"}},
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return "", withLocalnetLogs(ctx, codeContainer, err)
+	}
+	// nextest writes its progress and summary to stderr. Any exit code is accepted so that the summary can be read
+	// back when tests fail.
+	container := codeContainer.
+		WithExec([]string{"sh", "-c", cmd + " 2>&1"}, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	output, err := container.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	summary := nextestSummary(output)
+	if exitCode != 0 {
+		return summary, fmt.Errorf("nextest exited with code %d:\n%s", exitCode, summary)
+	}
+	return summary, nil
+}
+
+// nextestSummary returns the part of nextest's output from its final summary line on, which lists the counts of
+// passed, failed, flaky and skipped tests and the tests that failed. It returns the whole output if there is no
+// summary, e.g. because the build failed.
+func nextestSummary(output string) string {
+	lines := strings.Split(output, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "Summary [") {
+			return strings.Join(lines[i:], "\n")
+		}
+	}
+	return output
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// makeTargetPattern matches the make target names that can be passed as phase overrides. It leaves out shell
+// metacharacters and whitespace, since the targets end up in an `sh -c` command.
+var makeTargetPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// makeTarget returns override if it is set and a valid make target name, or fallback when it is empty.
+func makeTarget(phase, override, fallback string) (string, error) {
+	if override == "" {
+		return fallback, nil
+	}
+	if !makeTargetPattern.MatchString(override) {
+		return "", fmt.Errorf("invalid make target %q for the %s phase", override, phase)
+	}
+	return override, nil
+}
+
+// skippablePhases are the Test phases that can be skipped. The build can't be, every other phase runs on top of it.
+var skippablePhases = []string{"lint", "unit", "sdk", "cli", "doc"}
+
+// parseSkipPhases returns the set of phases named in skip, failing on names that aren't skippable phases so that a
+// typo doesn't silently run everything.
+func parseSkipPhases(skip []string) (map[string]bool, error) {
+	skipped := make(map[string]bool)
+	for _, phase := range skip {
+		if !slices.Contains(skippablePhases, phase) {
+			return nil, fmt.Errorf("unknown phase %q to skip, expected one of %s", phase, strings.Join(skippablePhases, ", "))
+		}
+		skipped[phase] = true
+	}
+	return skipped, nil
+}
+
+// skippedPhase returns the result of a phase that was skipped as requested.
+func skippedPhase() *PhaseResult {
+	return &PhaseResult{Stdout: "skipped as requested\n", Skipped: true}
+}
+
+// parsePhaseTimeout parses a Go duration string such as "20m" for the time each Test phase may take. An empty string
+// means no timeout.
+func parsePhaseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid phase timeout %q: %w", timeout, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid phase timeout %q: must not be negative", timeout)
+	}
+	return d, nil
+}
+
+// runPhase calls fn with ctx limited to timeout, or with ctx unchanged when timeout is zero, logging its start and
+// duration so that it is clear which phase is running, and recording it as a span. Dagger cancels the
+// pipeline fn is waiting on once the deadline passes, in which case the error says which phase ran out of time.
+func runPhase(ctx context.Context, phase string, timeout time.Duration, fn func(context.Context) error) (err error) {
+	infof("phase %s started", phase)
+	start := time.Now()
+	defer func() { infof("phase %s finished in %s", phase, time.Since(start).Round(time.Millisecond)) }()
+	ctx, endSpan := startPhaseSpan(ctx, phase)
+	defer func() { endSpan(err) }()
+	if timeout == 0 {
+		return fn(ctx)
+	}
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = fn(phaseCtx)
+	if err != nil && errors.Is(phaseCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("%s phase %w after %s: %w", phase, ErrPhaseTimeout, timeout, err)
+	}
+	return err
+}
+
+// The classes of phase failures, which a PhaseError matches with errors.Is.
+var (
+	// The phase ran out of its phaseTimeout
+	ErrPhaseTimeout = errors.New("timed out")
+	// The command failed because localnet or the target network couldn't be reached
+	ErrPhaseConnectivity = errors.New("network unreachable")
+	// The command exited with a non-zero code for any other reason, e.g. a failed assertion or build error
+	ErrPhaseCommand = errors.New("command failed")
+)
+
+// phaseErrorTailLines is how many lines of a failed command's stderr a PhaseError keeps.
+const phaseErrorTailLines = 20
+
+// PhaseError is returned by Test when one of its phases fails, so that callers can tell which phase failed and why
+// with errors.As. It matches ErrPhaseTimeout, ErrPhaseConnectivity or ErrPhaseCommand with errors.Is, depending on
+// how the phase failed, and unwraps to the underlying error, such as a *dagger.ExecError.
+type PhaseError struct {
+	// Phase that failed: build, lint, unit, sdk, cli, doc or localnet
+	Phase string
+	// Shell command the phase ran
+	Cmd string
+	// Exit code of the command, -1 when it didn't exit, e.g. because the phase timed out
+	ExitCode int
+	// Last lines of the command's stderr, or of its stdout when it wrote nothing to stderr
+	StderrTail string
+	Err        error
+}
+
+// newPhaseError returns a PhaseError for err, the failure of cmd in phase, taking the exit code and output from the
+// failed exec when there is one.
+func newPhaseError(phase, cmd string, err error) *PhaseError {
+	phaseErr := &PhaseError{Phase: phase, Cmd: cmd, ExitCode: -1, Err: err}
+	var execErr *dagger.ExecError
+	if errors.As(err, &execErr) {
+		phaseErr.ExitCode = execErr.ExitCode
+		output := execErr.Stderr
+		if strings.TrimSpace(output) == "" {
+			output = execErr.Stdout
+		}
+		lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+		phaseErr.StderrTail = strings.Join(lines[max(len(lines)-phaseErrorTailLines, 0):], "\n")
+	}
+	return phaseErr
+}
+
+func (e *PhaseError) Error() string {
+	return fmt.Sprintf("%s phase failed: %v", e.Phase, e.Err)
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the phase failed in the way target describes, target being one of the ErrPhase errors.
+func (e *PhaseError) Is(target error) bool {
+	switch target {
+	case ErrPhaseConnectivity:
+		return isConnectivityError(e.Err)
+	case ErrPhaseCommand:
+		var execErr *dagger.ExecError
+		return errors.As(e.Err, &execErr) && !errors.Is(e.Err, ErrPhaseTimeout) && !isConnectivityError(e.Err)
+	}
+	return false
+}
+
+// logPhaseOutput logs the output of a phase once it completes, each line prefixed with the phase name, so that the
+// output of a run can be followed phase by phase instead of only being returned at the end. The output of a failed
+// phase is part of err, so that is logged instead.
+func logPhaseOutput(phase, stdout string, err error) {
+	if err != nil {
+		errorf("%s", prefixLines("["+phase+"] ", err.Error()))
+		return
+	}
+	if stdout != "" {
+		infof("%s", prefixLines("["+phase+"] ", strings.TrimRight(stdout, "\n")))
+	}
+}
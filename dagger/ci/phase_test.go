@@ -0,0 +1,75 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestMakeTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		override string
+		want     string
+		wantErr  bool
+	}{
+		{name: "empty uses fallback", override: "", want: "test"},
+		{name: "plain", override: "test-unit", want: "test-unit"},
+		{name: "underscores and dots", override: "test_sdk.v2", want: "test_sdk.v2"},
+		{name: "leading digit", override: "2fast", want: "2fast"},
+		{name: "leading dash", override: "-f", wantErr: true},
+		{name: "leading dot", override: ".PHONY", wantErr: true},
+		{name: "whitespace", override: "test lint", wantErr: true},
+		{name: "semicolon", override: "test;rm -rf /", wantErr: true},
+		{name: "command substitution", override: "$(id)", wantErr: true},
+		{name: "backtick", override: "`id`", wantErr: true},
+		{name: "pipe", override: "test|sh", wantErr: true},
+		{name: "and", override: "test&&id", wantErr: true},
+		{name: "redirect", override: "test>out", wantErr: true},
+		{name: "quote", override: "test'", wantErr: true},
+		{name: "variable assignment", override: "CARGO=sh", wantErr: true},
+		{name: "newline", override: "test\nid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := makeTarget("unit", tt.override, "test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("makeTarget(%q) error = %v, wantErr %v", tt.override, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("makeTarget(%q) = %q, want %q", tt.override, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSkipPhases(t *testing.T) {
+	tests := []struct {
+		name    string
+		skip    []string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{name: "none", skip: nil, want: map[string]bool{}},
+		{name: "one", skip: []string{"doc"}, want: map[string]bool{"doc": true}},
+		{name: "several", skip: []string{"lint", "cli"}, want: map[string]bool{"lint": true, "cli": true}},
+		{name: "duplicates", skip: []string{"sdk", "sdk"}, want: map[string]bool{"sdk": true}},
+		{name: "all", skip: skippablePhases,
+			want: map[string]bool{"lint": true, "unit": true, "sdk": true, "cli": true, "doc": true}},
+		{name: "unknown", skip: []string{"docs"}, wantErr: true},
+		{name: "build can't be skipped", skip: []string{"build"}, wantErr: true},
+		{name: "unknown after known", skip: []string{"lint", "lnit"}, wantErr: true},
+		{name: "case sensitive", skip: []string{"Lint"}, wantErr: true},
+		{name: "empty name", skip: []string{""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSkipPhases(tt.skip)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSkipPhases(%q) error = %v, wantErr %v", tt.skip, err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("parseSkipPhases(%q) = %v, want %v", tt.skip, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// secretEnvPattern matches the names of environment variables whose values are left out of plans.
+var secretEnvPattern = regexp.MustCompile(`(?i)key|password|secret|token`)
+
+// planStep is a command that a pipeline runs, in the phase it belongs to.
+type planStep struct {
+	phase string
+	cmd   string
+}
+
+// describePlan renders what a pipeline on container would do without running it: the base image and how it is set
+// up, the environment and mounts of container, and the commands of each phase in order. Secret values are redacted.
+func describePlan(
+	ctx context.Context,
+	container *dagger.Container,
+	opts codeContainerOpts,
+	steps []planStep,
+) (string, error) {
+	var plan strings.Builder
+	fmt.Fprintf(&plan, "image: %s\n", rustImageRef(opts))
+	if opts.rustToolchain != "" {
+		fmt.Fprintf(&plan, "toolchain: %s\n", opts.rustToolchain)
+	}
+	if len(opts.extraPackages) > 0 {
+		fmt.Fprintf(&plan, "extra apt packages: %s\n", strings.Join(opts.extraPackages, " "))
+	}
+	if len(opts.cargoTools) > 0 {
+		fmt.Fprintf(&plan, "cargo tools: %s\n", strings.Join(opts.cargoTools, " "))
+	}
+
+	workdir, err := container.Workdir(ctx)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&plan, "workdir: %s\n", workdir)
+
+	envVariables, err := container.EnvVariables(ctx)
+	if err != nil {
+		return "", err
+	}
+	plan.WriteString("env:\n")
+	for _, envVariable := range envVariables {
+		name, err := envVariable.Name(ctx)
+		if err != nil {
+			return "", err
+		}
+		value, err := envVariable.Value(ctx)
+		if err != nil {
+			return "", err
+		}
+		if secretEnvPattern.MatchString(name) {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(&plan, "  %s=%s\n", name, value)
+	}
+
+	mounts, err := container.Mounts(ctx)
+	if err != nil {
+		return "", err
+	}
+	plan.WriteString("mounts:\n")
+	for _, mount := range mounts {
+		fmt.Fprintf(&plan, "  %s\n", mount)
+	}
+
+	plan.WriteString("commands:\n")
+	for i, step := range steps {
+		fmt.Fprintf(&plan, "  %d. [%s] %s\n", i+1, step.phase, step.cmd)
+	}
+	return plan.String(), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// cargoHackRunPattern matches the line cargo-hack prints before each command it runs, with the command and the
+// package as the submatches.
+var cargoHackRunPattern = regexp.MustCompile("(?m)^info: running `([^`]+)` on ([^ ]+)")
+
+// CheckFeaturePowerset runs `cargo check` on every crate of the workspace with every combination of its features,
+// using cargo-hack, to catch code that only compiles with some of them, e.g. only with the default features. If any
+// combination fails, the error lists each of them by crate and cargo flags. The number of combinations grows
+// exponentially with the number of features, excludeFeatures leaves features out of the combinations to bound it.
+func (m *Ci) CheckFeaturePowerset(
+	ctx context.Context,
+	// Features to leave out of the combinations, e.g. ones that only pull in optional dependencies
+	// +optional
+	excludeFeatures []string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if err := validateCargoFeatures(excludeFeatures); err != nil {
+		return "", err
+	}
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	source = filterSource(source)
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{cargoTools: []string{"cargo-hack"}})
+	if err != nil {
+		return "", err
+	}
+	// Every combination is checked even after one fails, so that the error lists all of the failing ones
+	cmd := "cargo hack check --locked --workspace --feature-powerset --keep-going"
+	if len(excludeFeatures) > 0 {
+		cmd += " --exclude-features " + strings.Join(excludeFeatures, ",")
+	}
+	check := m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec(
+			[]string{"sh", "-c", cmd + " 2>&1"},
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+		)
+	exitCode, err := check.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	output, err := check.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	runs := cargoHackRunPattern.FindAllStringSubmatchIndex(output, -1)
+	if exitCode != 0 {
+		return "", featurePowersetError(output, runs)
+	}
+	return fmt.Sprintf("every feature combination compiles, %d checked", len(runs)), nil
+}
+
+// featurePowersetError describes a failed check of the feature combinations, naming each crate and cargo command
+// whose output, up to the next command, has a compile error. runs are the matches of cargoHackRunPattern in output.
+func featurePowersetError(output string, runs [][]int) error {
+	var failed []string
+	for i, run := range runs {
+		end := len(output)
+		if i+1 < len(runs) {
+			end = runs[i+1][0]
+		}
+		if cargoCompileErrorPattern.MatchString(output[run[1]:end]) {
+			failed = append(failed, "  "+output[run[4]:run[5]]+": "+output[run[2]:run[3]])
+		}
+	}
+	if len(failed) == 0 {
+		return fmt.Errorf("checking the feature combinations failed:\n%s", output)
+	}
+	return fmt.Errorf("feature combinations that don't compile:\n%s\n\n%s", strings.Join(failed, "\n"), output)
+}
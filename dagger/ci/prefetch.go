@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// prefetchCmd fetches the locked dependencies and builds them against stubbed out workspace sources, the way the build
+// make target would build them, so that their artifacts land in the target cache without compiling the workspace. The
+// stub artifacts of the workspace crates are cleaned afterwards, since the real sources may be older than them and
+// would then be considered fresh. It prints the number of crates in the lockfile that were fetched.
+const prefetchCmd = `set -e
+cargo fetch --locked
+find . -path ./target -prune -o -name '*.rs' -print | while read -r f; do
+  case "$(basename "$f")" in
+    main.rs|build.rs) echo 'fn main() {}' > "$f" ;;
+    *) : > "$f" ;;
+  esac
+done
+cargo build --locked --profile "${CARGO_PROFILE:-release}" ${CARGO_FEATURE_FLAGS} >&2
+for pkg in $(cargo metadata --locked --no-deps --format-version 1 | jq -r '.packages[].name'); do
+  cargo clean --profile "${CARGO_PROFILE:-release}" -p "$pkg"
+done
+grep -c '^source = ' Cargo.lock`
+
+// Prefetch warms the cargo registry, git and target caches that Test and Build use, fetching the locked dependencies
+// and compiling them without building the workspace, so that CI can prime a cold engine once before running them.
+// It returns how many crates were fetched.
+func (m *Ci) Prefetch(
+	ctx context.Context,
+	// Cache namespace of the Test and Build runs to warm the caches of
+	// +optional
+	cacheNamespace string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if cacheNamespace != "" && !cacheNamespacePattern.MatchString(cacheNamespace) {
+		return "", fmt.Errorf("invalid cache namespace %q", cacheNamespace)
+	}
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	source = filterSource(source)
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{cacheNamespace: cacheNamespace})
+	if err != nil {
+		return "", err
+	}
+
+	// The sources are copied rather than mounted, so stubbing them out doesn't touch the ones given
+	crates, err := m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec([]string{"sh", "-c", prefetchCmd}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("prefetch dependencies: %w", err)
+	}
+	crates = strings.TrimSpace(crates)
+	infof("fetched and built %s crates", crates)
+	return fmt.Sprintf("fetched %s crates\n", crates), nil
+}
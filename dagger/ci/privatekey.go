@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// privateKeyPattern matches a private key as 32 bytes of hex, with or without the 0x prefix.
+var privateKeyPattern = regexp.MustCompile(`^(0x)?[0-9a-fA-F]{64}$`)
+
+// normalizePrivateKey returns key with the 0x prefix that the CLI and SDK expect, failing if it isn't 32 bytes of hex.
+// The error never includes the key.
+func normalizePrivateKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if !privateKeyPattern.MatchString(key) {
+		return "", fmt.Errorf("not a private key, expected 32 bytes of hex with or without a 0x prefix")
+	}
+	if !strings.HasPrefix(key, "0x") {
+		key = "0x" + key
+	}
+	return key, nil
+}
+
+// validatePrivateKey checks the private key in the secret called name before any container uses it, returning a
+// secret with the normalized key. Only whether the key is valid is logged, never the key.
+func validatePrivateKey(ctx context.Context, name string, key *dagger.Secret) (*dagger.Secret, error) {
+	plaintext, err := key.Plaintext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	normalized, err := normalizePrivateKey(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	debugf("%s is a valid private key", name)
+	if normalized == plaintext {
+		return key, nil
+	}
+	return dag.SetSecret(name, normalized), nil
+}
+
+// normalizeEnvPrivateKey checks and normalizes the private key variables that vars sets, RECALL_PRIVATE_KEY and its
+// numbered siblings.
+func normalizeEnvPrivateKey(vars []envVar) error {
+	for i, envVar := range vars {
+		if !privateKeyEnvPattern.MatchString(envVar.name) {
+			continue
+		}
+		key, err := normalizePrivateKey(envVar.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s env var: %w", envVar.name, err)
+		}
+		vars[i].value = key
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEnvPrivateKey(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+	vars := []envVar{
+		{"RECALL_PRIVATE_KEY", key},
+		{"RECALL_PRIVATE_KEY_2", "0x" + key},
+		{"RECALL_PRIVATE_KEY_10", " " + key + "\n"},
+		{"RECALL_PRIVATE_KEY_FILE", "/key"},
+		{"RUST_LOG", "debug"},
+	}
+	if err := normalizeEnvPrivateKey(vars); err != nil {
+		t.Fatalf("normalizeEnvPrivateKey() = %v", err)
+	}
+	want := []envVar{
+		{"RECALL_PRIVATE_KEY", "0x" + key},
+		{"RECALL_PRIVATE_KEY_2", "0x" + key},
+		{"RECALL_PRIVATE_KEY_10", "0x" + key},
+		{"RECALL_PRIVATE_KEY_FILE", "/key"},
+		{"RUST_LOG", "debug"},
+	}
+	if !slices.Equal(vars, want) {
+		t.Errorf("normalizeEnvPrivateKey() left %v, want %v", vars, want)
+	}
+
+	for _, name := range []string{"RECALL_PRIVATE_KEY", "RECALL_PRIVATE_KEY_3"} {
+		err := normalizeEnvPrivateKey([]envVar{{name, "0x1234"}})
+		if err == nil {
+			t.Errorf("normalizeEnvPrivateKey(%s=0x1234) = nil, want an error", name)
+		} else if !strings.Contains(err.Error(), name) || strings.Contains(err.Error(), "0x1234") {
+			t.Errorf("normalizeEnvPrivateKey(%s=0x1234) = %q, want an error naming the variable and not the key", name, err)
+		}
+	}
+}
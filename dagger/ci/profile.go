@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"dagger/ci/internal/dagger"
+	"github.com/BurntSushi/toml"
+)
+
+// builtinCargoProfiles are the profiles cargo defines without any configuration in Cargo.toml.
+var builtinCargoProfiles = map[string]bool{"dev": true, "release": true, "test": true, "bench": true}
+
+// cargoProfilePattern matches the names cargo allows for custom profiles.
+var cargoProfilePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateCargoProfile checks that profile is one of cargo's built-in profiles or defined in the workspace Cargo.toml
+// of source. An empty profile is the default, release.
+func validateCargoProfile(ctx context.Context, source *dagger.Directory, profile string) error {
+	if profile == "" || builtinCargoProfiles[profile] {
+		return nil
+	}
+	if !cargoProfilePattern.MatchString(profile) {
+		return fmt.Errorf("invalid cargo profile %q", profile)
+	}
+	manifest, err := source.File("Cargo.toml").Contents(ctx)
+	if err != nil {
+		return fmt.Errorf("read Cargo.toml: %w", err)
+	}
+	var cargoToml struct {
+		Profile map[string]toml.Primitive `toml:"profile"`
+	}
+	if _, err := toml.Decode(manifest, &cargoToml); err != nil {
+		return fmt.Errorf("parse Cargo.toml: %w", err)
+	}
+	if _, ok := cargoToml.Profile[profile]; !ok {
+		return fmt.Errorf("cargo profile %q is not defined in Cargo.toml", profile)
+	}
+	return nil
+}
+
+// profileDir returns the directory under target that cargo puts the artifacts of profile in.
+func profileDir(profile string) string {
+	switch profile {
+	case "", "release":
+		return "release"
+	case "dev", "test":
+		return "debug"
+	default:
+		return profile
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// versionPattern matches versions that are safe to use in object keys and shell commands. They start with a letter or
+// digit, so that a version is never taken for an option or a relative path like "..".
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// Publish builds the release `recall` binary and uploads it, along with a sha256 checksum file, a CycloneDX SBOM and a
+// SHA256SUMS file that is signed when signingKey is given, to an S3-compatible object store under recall/<version>/.
+// It refuses to overwrite a version that was already published unless force is set, and like Release uploads the
+// checksums last, so that a publish that failed half way can be re-run. It returns the URL of the uploaded binary. If
+// version is empty, it is derived from `git describe`, so the sources must then include the .git directory.
+func (m *Ci) Publish(
+	ctx context.Context,
+	// Version to publish, defaults to the output of `git describe --tags --always --dirty`
+	// +optional
+	version string,
+	// Bucket URL, e.g. https://s3.example.com/releases
+	bucketEndpoint string,
+	// Access key ID for the object store
+	accessKeyId string,
+	// Secret access key for the object store
+	accessKey *dagger.Secret,
+	// Region used to sign requests
+	// +optional
+	region string,
+	// Armored gpg private key to sign SHA256SUMS with, into SHA256SUMS.asc
+	// +optional
+	signingKey *dagger.Secret,
+	// Publish even if the version was already published, overwriting it
+	// +optional
+	force bool,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+	if !isHTTPURL(bucketEndpoint) {
+		return "", fmt.Errorf("invalid bucket endpoint %q, expected an http(s) URL", bucketEndpoint)
+	}
+	bucketEndpoint = strings.TrimSuffix(bucketEndpoint, "/")
+
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	if version == "" {
+		version, err = m.describeVersion(ctx, containerWithAuth, source)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !versionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid version %q", version)
+	}
+
+	prefix := bucketEndpoint + "/recall/" + version
+	tools := containerWithAuth.
+		From("debian:bookworm-slim").
+		WithExec([]string{"apt-get", "update"}).
+		WithExec([]string{"apt-get", "install", "-y", "--no-install-recommends", "ca-certificates", "curl"})
+	store := storeContainer(tools, region, accessKeyId, accessKey)
+	if err := checkUnpublished(ctx, store, version, prefix, force); err != nil {
+		return "", err
+	}
+
+	buildContainer, err := m.buildContainer(ctx, containerWithAuth, filterSource(source), codeContainerOpts{})
+	if err != nil {
+		return "", err
+	}
+	binary := extractBinary(buildContainer, "/src/target/release/recall")
+	sbom, err := m.sbom(ctx, containerWithAuth, filterSource(source), "cyclonedx")
+	if err != nil {
+		return "", err
+	}
+	artifacts := dag.Directory().
+		WithFile("recall", binary).
+		WithFile(sbomFormats["cyclonedx"].fileName, sbom)
+	dist, err := signArtifacts(ctx, containerWithAuth, artifacts, signingKey)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = store.
+		WithDirectory("/dist", dist).
+		WithWorkdir("/dist").
+		WithExec([]string{"sh", "-c", "sha256sum recall > recall.sha256"}).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", releaseUploadCmd, prefix}).
+		Sync(ctx)
+	if err != nil {
+		return "", fmt.Errorf("publish %s: %w", version, err)
+	}
+
+	url := prefix + "/recall"
+	infof("published recall %s to %s", version, url)
+	return url, nil
+}
+
+// describeVersion derives a version from the git history of the sources.
+func (m *Ci) describeVersion(
+	ctx context.Context,
+	containerWithAuth *dagger.Container,
+	source *dagger.Directory,
+) (string, error) {
+	version, err := m.rustContainer(containerWithAuth, codeContainerOpts{}).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec([]string{"git", "describe", "--tags", "--always", "--dirty"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("derive version from git: %w", err)
+	}
+	return strings.TrimSpace(version), nil
+}
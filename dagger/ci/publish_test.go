@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestVersionPattern(t *testing.T) {
+	for _, version := range []string{"v1.2.3", "1.2.3", "v1.2.3-rc.1", "v1.2.3+build.5", "v0.1.0-3-gabc1234-dirty",
+		"abc1234", "v1_2"} {
+		if !versionPattern.MatchString(version) {
+			t.Errorf("versionPattern rejects %q", version)
+		}
+	}
+	for _, version := range []string{"", "-v", "--force", ".", "..", ".hidden", "_v1", "+1", "v1/../..", "v1 2",
+		"v1;id", "$(id)", "v1\nid"} {
+		if versionPattern.MatchString(version) {
+			t.Errorf("versionPattern accepts %q", version)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// releaseCheckCmd fails unless the sources are a clean checkout, and prints whether the tag of the release, passed as
+// $0, exists and points at HEAD.
+const releaseCheckCmd = `changed=$(git status --porcelain)
+if [ -n "$changed" ]; then
+  echo "the working tree isn't clean, commit or stash these first:" >&2
+  echo "$changed" >&2
+  exit 1
+fi
+if ! tag=$(git rev-parse -q --verify "refs/tags/$0^{commit}"); then
+  echo missing
+elif [ "$tag" = "$(git rev-parse HEAD)" ]; then
+  echo head
+else
+  echo "tag $0 exists but points at $tag, not at HEAD" >&2
+  exit 1
+fi`
+
+// releaseExistsCmd prints the HTTP status of the SHA256SUMS of the release at $0, which is uploaded last and so only
+// exists once a release was published completely.
+const releaseExistsCmd = `curl -sS -o /dev/null -w '%{http_code}' -I --aws-sigv4 "aws:amz:$REGION:s3" ` +
+	`--user "$ACCESS_KEY_ID:$SECRET_ACCESS_KEY" "$0/` + checksumsFile + `"`
+
+// releaseUploadCmd uploads every file under the working directory to the prefix passed as $0, keeping their paths.
+// The checksums and their signature go last, so that a release only looks published once everything else is.
+const releaseUploadCmd = `upload() {
+  curl -fsS --aws-sigv4 "aws:amz:$REGION:s3" --user "$ACCESS_KEY_ID:$SECRET_ACCESS_KEY" -T "$1" "$prefix/$1"
+}
+prefix=$0
+files=$(find . -type f ! -name ` + checksumsFile + ` ! -name ` + checksumsSignature + ` | sed 's|^\./||' | sort)
+for file in $files; do
+  upload "$file" || exit 1
+  echo "$file"
+done
+for file in ` + checksumsFile + ` ` + checksumsSignature + `; do
+  [ -f "$file" ] || continue
+  upload "$file" || exit 1
+  echo "$file"
+done`
+
+// Release publishes version: it checks that the sources are a clean checkout whose tag of the version, if it exists,
+// points at HEAD, builds the `recall` binary for each platform, adds a CycloneDX SBOM and the changelog since the
+// previous tag, checksums and optionally signs everything, and uploads it to an S3-compatible object store under
+// recall/<version>/. It refuses to overwrite a version that was already published unless force is set; a release
+// that failed half way can be re-run, since it only counts as published once its SHA256SUMS is uploaded, which
+// happens last. It returns a summary of what was published and where. The sources must include the .git directory.
+func (m *Ci) Release(
+	ctx context.Context,
+	// Version to release, e.g. v0.2.0, also the name of its git tag
+	version string,
+	// Bucket URL, e.g. https://s3.example.com/releases
+	bucketEndpoint string,
+	// Access key ID for the object store
+	accessKeyId string,
+	// Secret access key for the object store
+	accessKey *dagger.Secret,
+	// Region used to sign requests
+	// +optional
+	region string,
+	// Armored gpg private key to sign SHA256SUMS with, into SHA256SUMS.asc
+	// +optional
+	signingKey *dagger.Secret,
+	// +optional
+	platforms []string,
+	// Publish even if the version was already published, overwriting it
+	// +optional
+	force bool,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	if !versionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid version %q", version)
+	}
+	if !isHTTPURL(bucketEndpoint) {
+		return "", fmt.Errorf("invalid bucket endpoint %q, expected an http(s) URL", bucketEndpoint)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	prefix := strings.TrimSuffix(bucketEndpoint, "/") + "/recall/" + version
+
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	tools := containerWithAuth.
+		From("debian:bookworm-slim").
+		WithExec([]string{"apt-get", "update"}).
+		WithExec([]string{"apt-get", "install", "-y", "--no-install-recommends", "ca-certificates", "curl", "git"})
+	tag, err := tools.
+		WithDirectory("/src", source.WithoutDirectory("target")).
+		WithWorkdir("/src").
+		WithExec([]string{"git", "config", "--global", "--add", "safe.directory", "/src"}).
+		WithExec([]string{"sh", "-c", releaseCheckCmd, version}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("check the sources of %s: %w", version, err)
+	}
+	tagged := strings.TrimSpace(tag) == "head"
+
+	store := storeContainer(tools, region, accessKeyId, accessKey)
+	if err := checkUnpublished(ctx, store, version, prefix, force); err != nil {
+		return "", err
+	}
+
+	binaries, err := m.BuildMatrix(ctx, platforms, 0, nil, dockerUsername, dockerPassword, source)
+	if err != nil {
+		return "", err
+	}
+	sbom, err := m.sbom(ctx, containerWithAuth, filterSource(source), "cyclonedx")
+	if err != nil {
+		return "", err
+	}
+	// The changelog runs up to HEAD, which the tag, when it exists, points at
+	changelog, err := m.Changelog(ctx, "", "", dockerUsername, dockerPassword, source)
+	if err != nil {
+		return "", err
+	}
+	artifacts := binaries.
+		WithFile(sbomFormats["cyclonedx"].fileName, sbom).
+		WithNewFile("CHANGELOG.md", changelog)
+	dist, err := signArtifacts(ctx, containerWithAuth, artifacts, signingKey)
+	if err != nil {
+		return "", err
+	}
+
+	uploaded, err := store.
+		WithDirectory("/dist", dist).
+		WithWorkdir("/dist").
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", releaseUploadCmd, prefix}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("publish %s: %w", version, err)
+	}
+	infof("published recall %s to %s", version, prefix)
+
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "Published recall %s to %s/\n\n", version, prefix)
+	for _, file := range strings.Fields(uploaded) {
+		fmt.Fprintf(&summary, "  %s/%s\n", prefix, file)
+	}
+	if signingKey == nil {
+		summary.WriteString("\n" + checksumsFile + " is not signed, no signingKey was given\n")
+	}
+	if !tagged {
+		fmt.Fprintf(&summary, "\nThere is no %s tag yet, tag the released commit with:\n\n", version)
+		fmt.Fprintf(&summary, "  git tag %s && git push origin %s\n", version, version)
+	}
+	return summary.String(), nil
+}
+
+// storeContainer returns tools with the object store credentials set for releaseExistsCmd and releaseUploadCmd. The
+// credentials are only referenced through the environment so that they never show up in the logs.
+func storeContainer(tools *dagger.Container, region, accessKeyId string, accessKey *dagger.Secret) *dagger.Container {
+	return tools.
+		WithEnvVariable("REGION", region).
+		WithEnvVariable("ACCESS_KEY_ID", accessKeyId).
+		WithSecretVariable("SECRET_ACCESS_KEY", accessKey)
+}
+
+// checkUnpublished fails if version was already published to prefix, unless force is set, in which case it only
+// warns that the version is overwritten. store must have been set up by storeContainer.
+func checkUnpublished(ctx context.Context, store *dagger.Container, version, prefix string, force bool) error {
+	status, err := store.
+		// Whether the release exists has to be checked on every run
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", releaseExistsCmd, prefix}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("check whether %s was already published: %w", version, err)
+	}
+	switch status = strings.TrimSpace(status); {
+	case status == "200" && !force:
+		return fmt.Errorf("%s was already published to %s, set force to overwrite it", version, prefix)
+	case status == "200":
+		warnf("overwriting %s, which was already published to %s", version, prefix)
+	case status != "404" && status != "403":
+		// S3 answers 403 rather than 404 for missing objects when the key can't list the bucket
+		return fmt.Errorf("check whether %s was already published: unexpected HTTP status %s", version, status)
+	}
+	return nil
+}
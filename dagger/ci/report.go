@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// reportVersion is the version of the JSON reports. It only changes when a field is removed or changes meaning, new
+// fields are added without changing it.
+const reportVersion = 1
+
+// testReport is the JSON form of a TestResult.
+type testReport struct {
+	// Always reportVersion
+	Version int `json:"version"`
+	// Whether every phase passed
+	Passed bool `json:"passed"`
+	// Every phase in the order Test runs them: lint, unit, sdk, cli and doc
+	Phases []phaseReport `json:"phases"`
+	// Fields of the result holding files, e.g. "report", to export with `dagger call test ... <field> export`
+	Artifacts []string `json:"artifacts"`
+	// Cache hit rates of the build, only set with useSccache
+	Sccache string `json:"sccache,omitempty"`
+	// What the run would do, only set with dryRun, in which case phases are all "not run"
+	Plan string `json:"plan,omitempty"`
+}
+
+// phaseReport is the JSON form of a PhaseResult, and of a StageResult of All.
+type phaseReport struct {
+	Name string `json:"name"`
+	// One of "passed", "failed", "skipped" or "not run"
+	Status string `json:"status"`
+	// How long the phase took in milliseconds, 0 when it didn't run
+	DurationMs int64 `json:"duration_ms"`
+	// Why the phase failed or was skipped
+	Error string `json:"error,omitempty"`
+}
+
+// newPhaseReport returns the report of the phase called name, which didn't run when result is nil. The error of a
+// skipped phase is why it was skipped, which PhaseResult keeps in its output rather than its error.
+func newPhaseReport(name string, result *PhaseResult) phaseReport {
+	report := phaseReport{Name: name, Status: "not run"}
+	switch {
+	case result == nil:
+		return report
+	case result.Skipped:
+		report.Status = "skipped"
+		report.Error = result.Stdout
+		return report
+	case result.Passed:
+		report.Status = "passed"
+	default:
+		report.Status = "failed"
+		report.Error = result.Error
+	}
+	report.DurationMs = durationMs(result.Duration)
+	return report
+}
+
+// durationMs converts a Go duration string to milliseconds, 0 when it is empty or invalid.
+func durationMs(duration string) int64 {
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return 0
+	}
+	return d.Milliseconds()
+}
+
+// JSON returns the result as JSON for tools that consume it, such as dashboards and bots. Its fields are documented
+// in the README and stay stable within a version, which the report carries.
+func (r *TestResult) JSON() (string, error) {
+	report := testReport{
+		Version:   reportVersion,
+		Passed:    r.Passed,
+		Artifacts: []string{},
+		Sccache:   r.Sccache,
+		Plan:      r.Plan,
+	}
+	for _, phase := range []struct {
+		name   string
+		result *PhaseResult
+	}{
+		{"lint", r.Lint},
+		{"unit", r.Unit},
+		{"sdk", r.Sdk},
+		{"cli", r.Cli},
+		{"doc", r.Doc},
+	} {
+		report.Phases = append(report.Phases, newPhaseReport(phase.name, phase.result))
+	}
+	if r.Report != nil {
+		report.Artifacts = append(report.Artifacts, "report")
+	}
+	if r.LocalnetLogs != nil {
+		report.Artifacts = append(report.Artifacts, "localnet-logs")
+	}
+	return marshalReport(report)
+}
+
+// allReport is the JSON form of an AllResult.
+type allReport struct {
+	// Always reportVersion
+	Version int `json:"version"`
+	// Whether every stage passed
+	Passed bool `json:"passed"`
+	// Stages in the order they finished
+	Stages []phaseReport `json:"stages"`
+}
+
+// JSON returns the result as JSON, in the same format as the JSON of Test with stages instead of phases.
+func (r *AllResult) JSON() (string, error) {
+	report := allReport{Version: reportVersion, Passed: r.Passed, Stages: []phaseReport{}}
+	for _, stage := range r.Stages {
+		stageReport := phaseReport{Name: stage.Name, Status: "passed", Error: stage.Error}
+		switch {
+		case stage.Skipped:
+			stageReport.Status = "skipped"
+		case !stage.Passed:
+			stageReport.Status = "failed"
+		}
+		if !stage.Skipped {
+			stageReport.DurationMs = durationMs(stage.Duration)
+		}
+		report.Stages = append(report.Stages, stageReport)
+	}
+	return marshalReport(report)
+}
+
+// marshalReport returns report as indented JSON ending in a newline.
+func marshalReport(report any) (string, error) {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal report: %w", err)
+	}
+	return string(out) + "\n", nil
+}
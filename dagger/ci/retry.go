@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// connectivityErrorPatterns are lowercase fragments of error output that indicate a test failed because localnet
+// couldn't be reached, rather than because of an assertion.
+var connectivityErrorPatterns = []string{
+	"connection refused",
+	"connection reset",
+	"connection closed",
+	"broken pipe",
+	"error sending request",
+	"tcp connect error",
+	"dns error",
+	"operation timed out",
+	"deadline has elapsed",
+}
+
+// runWithRetry runs cmd in container, re-running it up to attempts times in total while it fails with a connectivity
+// error. The delay between attempts starts at backoff and doubles after each attempt. The container of the last
+// attempt is returned along with its output, and the last error if all attempts fail.
+func runWithRetry(
+	ctx context.Context,
+	container *dagger.Container,
+	cmd []string,
+	attempts int,
+	backoff time.Duration,
+) (*dagger.Container, string, error) {
+	for attempt := 1; ; attempt++ {
+		// Bust the cache so that a retry actually runs the command again
+		attemptContainer := container.
+			WithEnvVariable("CI_ATTEMPT", strconv.Itoa(attempt)).
+			WithExec(cmd)
+		stdout, err := attemptContainer.Stdout(ctx)
+		if err == nil || attempt >= attempts || !isConnectivityError(err) {
+			return attemptContainer, stdout, err
+		}
+
+		warnf("%s failed with a connectivity error (attempt %d/%d), retrying in %s",
+			strings.Join(cmd, " "), attempt, attempts, backoff)
+		select {
+		case <-ctx.Done():
+			return attemptContainer, stdout, errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isConnectivityError reports whether err is a failed exec whose output points at a connectivity problem.
+func isConnectivityError(err error) bool {
+	var execErr *dagger.ExecError
+	if !errors.As(err, &execErr) {
+		return false
+	}
+	output := strings.ToLower(execErr.Stdout + execErr.Stderr)
+	for _, pattern := range connectivityErrorPatterns {
+		if strings.Contains(output, pattern) {
+			return true
+		}
+	}
+	return false
+}
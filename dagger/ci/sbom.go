@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/ci/internal/dagger"
+)
+
+// sbomFormats maps the SBOM formats to the cargo-sbom output format and the file name the SBOM is given.
+var sbomFormats = map[string]struct{ outputFormat, fileName string }{
+	"cyclonedx": {"cyclone_dx_json_1_4", "recall.cdx.json"},
+	"spdx":      {"spdx_json_2_3", "recall.spdx.json"},
+}
+
+// Sbom returns a Software Bill of Materials of the workspace, with the exact versions of the dependencies locked in
+// Cargo.lock, as CycloneDX or SPDX JSON.
+func (m *Ci) Sbom(
+	ctx context.Context,
+	// Format of the SBOM, cyclonedx or spdx
+	// +optional
+	// +default="cyclonedx"
+	format string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.File, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+	return m.sbom(ctx, containerWithAuth, filterSource(source), format)
+}
+
+// sbom generates the SBOM of the filtered sources in format with cargo-sbom.
+func (m *Ci) sbom(
+	ctx context.Context,
+	containerWithAuth *dagger.Container,
+	source *dagger.Directory,
+	format string,
+) (*dagger.File, error) {
+	sbomFormat, ok := sbomFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("invalid SBOM format %q, expected cyclonedx or spdx", format)
+	}
+	sbom, err := m.rustContainer(containerWithAuth, codeContainerOpts{cargoTools: []string{"cargo-sbom"}}).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		// cargo-sbom resolves the dependencies itself, this makes sure it finds them as Cargo.lock has them
+		WithExec([]string{"sh", "-c", "cargo metadata --locked --format-version 1 > /dev/null"}).
+		WithExec([]string{
+			"sh", "-c", "cargo sbom --output-format " + sbomFormat.outputFormat + " > /" + sbomFormat.fileName,
+		}).
+		File("/" + sbomFormat.fileName).
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("generate the %s SBOM: %w", format, err)
+	}
+	return sbom, nil
+}
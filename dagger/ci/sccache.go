@@ -0,0 +1,14 @@
+package main
+
+import "strings"
+
+// sccacheSummary extracts the cache hit rates from the output of `sccache --show-stats`.
+func sccacheSummary(output string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "Cache hits rate") {
+			b.WriteString("sccache: " + strings.Join(strings.Fields(line), " ") + "\n")
+		}
+	}
+	return b.String()
+}
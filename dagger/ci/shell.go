@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Shell opens an interactive shell in the code container the tests run in, with the CLI built and installed, the
+// caches mounted, networks.toml written and localnet bound once it produces blocks, for reproducing failures by hand.
+// The container is returned after the session ends, so that it can be chained further.
+func (m *Ci) Shell(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Container, error) {
+	codeContainer, localnet, err := m.localnetSetup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{}, codeContainerOpts{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return nil, withLocalnetLogs(ctx, codeContainer, err)
+	}
+	return codeContainer.Terminal(dagger.ContainerTerminalOpts{Cmd: []string{"bash"}}), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/ci/internal/dagger"
+)
+
+// checksumsFile lists the sha256 of every artifact, and checksumsSignature is its detached, armored gpg signature.
+const (
+	checksumsFile      = "SHA256SUMS"
+	checksumsSignature = "SHA256SUMS.asc"
+)
+
+// signArtifacts returns dir with a SHA256SUMS file covering every file in it, in the format `sha256sum -c` checks.
+// When key, an armored gpg private key without a passphrase, is given, SHA256SUMS is also signed into
+// SHA256SUMS.asc, which `gpg --verify SHA256SUMS.asc SHA256SUMS` checks against the public key.
+//
+// The key is only ever mounted as a secret and imported into a temporary keyring, so that it isn't written to any
+// layer that the engine caches or that is exported.
+func signArtifacts(
+	ctx context.Context,
+	containerWithAuth *dagger.Container,
+	dir *dagger.Directory,
+	key *dagger.Secret,
+) (*dagger.Directory, error) {
+	container := containerWithAuth.
+		From("debian:bookworm-slim").
+		WithDirectory("/artifacts", dir).
+		WithWorkdir("/artifacts").
+		WithExec([]string{
+			"sh", "-c",
+			"find . -type f ! -name " + checksumsFile + " ! -name " + checksumsSignature + " | sed 's|^\\./||' | " +
+				"sort | xargs -r sha256sum > " + checksumsFile,
+		})
+	if key != nil {
+		container = container.
+			WithExec([]string{"apt-get", "update"}).
+			WithExec([]string{"apt-get", "install", "-y", "--no-install-recommends", "gnupg"}).
+			WithMountedSecret("/run/secrets/signing-key", key).
+			WithMountedTemp("/gnupg").
+			WithEnvVariable("GNUPGHOME", "/gnupg").
+			WithExec([]string{
+				"sh", "-c",
+				"chmod 700 /gnupg && gpg --batch --import /run/secrets/signing-key && " +
+					"gpg --batch --yes --armor --detach-sign --output " + checksumsSignature + " " + checksumsFile,
+			})
+	}
+	signed, err := container.Directory("/artifacts").Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("sign artifacts: %w", err)
+	}
+	return signed, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Size builds the release `recall` binary and reports its size, optionally broken down by crate with cargo-bloat.
+// Given a baseline, either a previous build of the binary or a file with its size in bytes, the report includes the
+// difference to it. It fails if the binary is larger than maxBytes.
+func (m *Ci) Size(
+	ctx context.Context,
+	// Maximum size of the binary in bytes
+	// +optional
+	maxBytes int,
+	// Binary, or file with the size in bytes, to compare against
+	// +optional
+	baseline *dagger.File,
+	// Break the size down by crate with cargo-bloat
+	// +optional
+	byCrate bool,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	opts := codeContainerOpts{}
+	if byCrate {
+		opts.cargoTools = []string{"cargo-bloat"}
+	}
+	container, err := m.buildContainer(ctx, containerWithAuth, filterSource(source), opts)
+	if err != nil {
+		return "", err
+	}
+	size, err := extractBinary(container, "/src/target/release/recall").Size(ctx)
+	if err != nil {
+		return "", fmt.Errorf("build recall binary: %w", err)
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "recall binary: %s\n", formatBytes(size))
+	if baseline != nil {
+		baselineSize, err := baselineBytes(ctx, baseline)
+		if err != nil {
+			return "", err
+		}
+		delta := size - baselineSize
+		fmt.Fprintf(&report, "baseline: %s, change: %+d bytes", formatBytes(baselineSize), delta)
+		if baselineSize > 0 {
+			fmt.Fprintf(&report, " (%+.2f%%)", 100*float64(delta)/float64(baselineSize))
+		}
+		report.WriteString("\n")
+	}
+	if byCrate {
+		crates, err := container.
+			WithExec([]string{"cargo", "bloat", "--release", "-p", "recall_cli", "--crates", "-n", "20"}).
+			Stdout(ctx)
+		if err != nil {
+			return "", fmt.Errorf("cargo bloat: %w", err)
+		}
+		fmt.Fprintf(&report, "\n%s", crates)
+	}
+
+	if maxBytes > 0 && size > maxBytes {
+		return report.String(), fmt.Errorf(
+			"recall binary is %s, more than the maximum of %s", formatBytes(size), formatBytes(maxBytes),
+		)
+	}
+	return report.String(), nil
+}
+
+// baselineBytes returns the size recorded in baseline, which is either a small file holding a number of bytes or a
+// binary whose size is the baseline.
+func baselineBytes(ctx context.Context, baseline *dagger.File) (int, error) {
+	size, err := baseline.Size(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("read baseline: %w", err)
+	}
+	// A file this small can't be a binary, so it holds a recorded size
+	if size <= 32 {
+		contents, err := baseline.Contents(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("read baseline: %w", err)
+		}
+		recorded, err := strconv.Atoi(strings.TrimSpace(contents))
+		if err != nil {
+			return 0, fmt.Errorf("invalid baseline size %q, expected a number of bytes", strings.TrimSpace(contents))
+		}
+		return recorded, nil
+	}
+	return size, nil
+}
+
+// formatBytes formats a size in bytes along with its size in MiB.
+func formatBytes(size int) string {
+	return fmt.Sprintf("%d bytes (%.2f MiB)", size, float64(size)/(1<<20))
+}
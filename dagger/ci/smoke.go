@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// smokeScript creates a bucket, adds an object to it, waits for a query to list it, and checks that it downloads with
+// the uploaded contents. It prints the object's hash on success.
+const smokeScript = `set -eu
+printf 'recall smoke test %s\n' "$CACHE_BUSTER" > /tmp/smoke-object
+address=$(recall bucket create | jq -r .address)
+echo "created bucket $address" >&2
+recall bucket add --address "$address" --key smoke/object /tmp/smoke-object > /dev/null
+
+hash=""
+for i in $(seq 1 30); do
+  hash=$(recall bucket query --address "$address" --prefix smoke/ |
+    jq -r '.objects[] | select(.key == "smoke/object") | .value.hash')
+  [ -n "$hash" ] && break
+  sleep 2
+done
+if [ -z "$hash" ]; then
+  echo "object smoke/object was not listed by a bucket query" >&2
+  exit 1
+fi
+
+for i in $(seq 1 30); do
+  if recall bucket get --address "$address" smoke/object > /tmp/smoke-download 2> /tmp/smoke-get.log &&
+    cmp -s /tmp/smoke-object /tmp/smoke-download; then
+    echo "$hash"
+    exit 0
+  fi
+  sleep 2
+done
+echo "object smoke/object could not be downloaded with its uploaded contents:" >&2
+cat /tmp/smoke-get.log >&2
+exit 1
+`
+
+// Smoke runs a quick end-to-end check of the object storage path against localnet: it creates a bucket with the
+// installed CLI, adds an object, queries it back and downloads it. It returns the hash of the stored object.
+func (m *Ci) Smoke(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	codeContainer, localnet, err := m.setup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{},
+		codeContainerOpts{},
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return "", err
+	}
+	hash, err := codeContainer.
+		WithServiceBinding("localnet", localnet).
+		// The object has to be stored on every run, so never reuse a cached result
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", smokeScript}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("smoke test failed: %w", err)
+	}
+	return "smoke test passed, object hash " + strings.TrimSpace(hash), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// openSSLVersion is the OpenSSL release built against musl for static binaries, since Debian only packages it for
+// glibc.
+const openSSLVersion = "3.0.15"
+
+// openSSLSHA256 is the SHA256 of the openSSLVersion source tarball, as published next to it in the OpenSSL release,
+// which the build checks the download against before extracting it.
+const openSSLSHA256 = "23c666d0edf20f14249b3d8f0368acaee9ab585b09e1de82107c66e1f3ec9533"
+
+// BuildStatic builds a fully static `recall` binary for a musl target and returns a directory with it and a SHA256SUMS
+// file, which is signed when signingKey is given. The target architecture has to match the engine's, since musl-tools
+// only provides a native musl-gcc.
+func (m *Ci) BuildStatic(
+	ctx context.Context,
+	// Rust target triple, defaults to x86_64-unknown-linux-musl
+	// +optional
+	target string,
+	// Armored gpg private key to sign SHA256SUMS with, into SHA256SUMS.asc
+	// +optional
+	signingKey *dagger.Secret,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	if target == "" {
+		target = "x86_64-unknown-linux-musl"
+	}
+	arch, _, _ := strings.Cut(target, "-")
+	if !strings.HasSuffix(target, "-linux-musl") || (arch != "x86_64" && arch != "aarch64") {
+		return nil, fmt.Errorf("unsupported static target %q, expected x86_64 or aarch64 linux-musl", target)
+	}
+
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	source = filterSource(source)
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{extraPackages: []string{"musl-tools", "perl"}})
+	if err != nil {
+		return nil, err
+	}
+
+	// The cc crate and openssl-sys look these up by target, with dashes replaced by underscores
+	targetEnv := strings.ReplaceAll(target, "-", "_")
+	container := m.rustContainer(containerWithAuth, opts).
+		WithExec([]string{
+			"sh", "-c",
+			`[ "$(uname -m)" = "` + arch + `" ] || { echo "cannot build ` + target + ` on $(uname -m)" >&2; exit 1; }`,
+		}).
+		// Missing kernel headers are picked up from the system include directories after the musl ones
+		WithExec([]string{
+			"sh", "-c",
+			"curl -fsSL -o /tmp/openssl.tar.gz https://github.com/openssl/openssl/releases/download/openssl-" +
+				openSSLVersion + "/openssl-" + openSSLVersion + ".tar.gz && " +
+				"echo '" + openSSLSHA256 + "  /tmp/openssl.tar.gz' | sha256sum -c - && " +
+				"tar xzf /tmp/openssl.tar.gz -C /tmp && " +
+				"cd /tmp/openssl-" + openSSLVersion + " && " +
+				`CC="musl-gcc -idirafter /usr/include -idirafter /usr/include/$(uname -m)-linux-gnu" ` +
+				"./Configure no-shared no-async --prefix=/usr/local/musl --libdir=lib linux-" + arch + " && " +
+				"make -j$(nproc) && make install_sw",
+		}).
+		WithEnvVariable("OPENSSL_DIR", "/usr/local/musl").
+		WithEnvVariable("OPENSSL_STATIC", "1").
+		WithEnvVariable("CC_"+targetEnv, "musl-gcc").
+		WithEnvVariable("CARGO_TARGET_"+strings.ToUpper(targetEnv)+"_LINKER", "musl-gcc").
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec([]string{"rustup", "target", "add", target}).
+		WithExec([]string{"cargo", "build", "--locked", "--release", "--target", target, "-p", "recall_cli"})
+
+	binary := extractBinary(container, "/src/target/"+target+"/release/recall")
+	// ldd reports static-pie binaries as "statically linked" and non-PIE ones as "not a dynamic executable"
+	_, err = container.
+		WithFile("/tmp/recall", binary).
+		WithExec([]string{
+			"sh", "-c",
+			"ldd /tmp/recall 2>&1 | grep -qE 'not a dynamic executable|statically linked' || " +
+				"{ echo 'recall binary is dynamically linked:' >&2; ldd /tmp/recall >&2; exit 1; }",
+		}).
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("build static binary for %s: %w", target, err)
+	}
+	return signArtifacts(ctx, containerWithAuth, dag.Directory().WithFile("recall", binary), signingKey)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+
+	"dagger/ci/internal/dagger"
+)
+
+// TestSdk builds and installs the CLI and runs only the SDK integration tests against localnet, for changes that
+// can't affect the CLI tests.
+func (m *Ci) TestSdk(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	return m.testSuite(
+		ctx, integrationSuite{name: "sdk", cmd: "make run-sdk-tests"},
+		localnetImage, registry, dockerUsername, dockerPassword, source,
+	)
+}
+
+// TestCli builds and installs the CLI and runs only the CLI integration tests against localnet, for changes that
+// can't affect the SDK tests.
+func (m *Ci) TestCli(
+	ctx context.Context,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	return m.testSuite(
+		ctx, integrationSuite{name: "cli", cmd: "make run-cli-tests"},
+		localnetImage, registry, dockerUsername, dockerPassword, source,
+	)
+}
+
+// testSuite runs a single integration suite the way Test does, in the code container bound to localnet once it
+// produces blocks, and appends the tail of the localnet logs to the error if it fails.
+func (m *Ci) testSuite(
+	ctx context.Context,
+	suite integrationSuite,
+	localnetImage string,
+	registry string,
+	dockerUsername string,
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	codeContainer, localnet, err := m.localnetSetup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{}, codeContainerOpts{},
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return "", withLocalnetLogs(ctx, codeContainer, err)
+	}
+	result := m.runIntegrationSuites(ctx, codeContainer, []integrationSuite{suite}, nil, 0, 0)[0]
+	if result.err != nil {
+		return result.stdout, withLocalnetLogs(ctx, codeContainer, result.err)
+	}
+	return result.stdout, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Summary renders the result as markdown for CI job summaries such as GitHub's $GITHUB_STEP_SUMMARY: a table with the
+// status and duration of each phase, followed by the output of each failed phase in a collapsible section.
+func (r *TestResult) Summary() string {
+	var summary, failures strings.Builder
+	status := "✅ passed"
+	if !r.Passed {
+		status = "❌ failed"
+	}
+	fmt.Fprintf(&summary, "## Test %s\n\n", status)
+	summary.WriteString("| Phase | Status | Duration |\n| --- | --- | --- |\n")
+	for _, phase := range []struct {
+		name   string
+		result *PhaseResult
+	}{
+		{"lint", r.Lint},
+		{"unit", r.Unit},
+		{"sdk", r.Sdk},
+		{"cli", r.Cli},
+		{"doc", r.Doc},
+	} {
+		result := phase.result
+		switch {
+		case result == nil:
+			fmt.Fprintf(&summary, "| %s | ⚪ not run | |\n", phase.name)
+		case result.Skipped:
+			fmt.Fprintf(&summary, "| %s | ⏭️ skipped | |\n", phase.name)
+		case result.Passed:
+			fmt.Fprintf(&summary, "| %s | ✅ passed | %s |\n", phase.name, result.Duration)
+		default:
+			fmt.Fprintf(&summary, "| %s | ❌ failed | %s |\n", phase.name, result.Duration)
+			fmt.Fprintf(&failures, "\n<details>\n<summary>%s output</summary>\n\n```\n%s\n```\n\n</details>\n",
+				phase.name, strings.TrimRight(strings.ReplaceAll(result.Error, "```", "` ` `"), "\n"))
+		}
+	}
+	if r.Sccache != "" {
+		fmt.Fprintf(&summary, "\nsccache: %s\n", strings.TrimSpace(r.Sccache))
+	}
+	return summary.String() + failures.String()
+}
+
+// SummaryFile returns Summary as a file, to export to the path CI reads the job summary from.
+func (r *TestResult) SummaryFile(
+	// Name of the file
+	// +optional
+	// +default="summary.md"
+	name string,
+) *dagger.File {
+	return dag.Directory().WithNewFile(name, r.Summary()).File(name)
+}
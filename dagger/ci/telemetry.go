@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// phaseTracerKey is the context key of the tracer that runPhase records its spans with.
+type phaseTracerKey struct{}
+
+// phaseTracer returns the tracer set on ctx with withPhaseTracer, or Dagger's, which shows the phase spans in the
+// Dagger trace of the call and exports them wherever its telemetry goes.
+func phaseTracer(ctx context.Context) trace.Tracer {
+	if tracer, ok := ctx.Value(phaseTracerKey{}).(trace.Tracer); ok {
+		return tracer
+	}
+	return Tracer()
+}
+
+// withPhaseTracer returns ctx with tracer set for the phase spans.
+func withPhaseTracer(ctx context.Context, tracer trace.Tracer) context.Context {
+	return context.WithValue(ctx, phaseTracerKey{}, tracer)
+}
+
+// validateOtlpEndpoint checks that endpoint is an http or https URL.
+func validateOtlpEndpoint(endpoint string) error {
+	if !isHTTPURL(endpoint) {
+		return fmt.Errorf("invalid OTLP endpoint %q, expected an http or https URL", endpoint)
+	}
+	return nil
+}
+
+// otlpTracer returns a tracer that exports its spans over OTLP/HTTP to endpoint, along with a function that flushes
+// the spans still buffered and shuts the exporter down. The spans are children of the Dagger span in ctx, so they share
+// the trace ID of the Dagger call.
+func otlpTracer(ctx context.Context, endpoint string) (trace.Tracer, func(), error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, nil, fmt.Errorf("create OTLP exporter for %s: %w", endpoint, err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("recall-ci"))),
+	)
+	shutdown := func() {
+		// Flush even when the run was cancelled, the spans of a cancelled run are the interesting ones
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			warnf("export phase spans to %s: %v", endpoint, err)
+		}
+	}
+	return provider.Tracer("dagger/ci"), shutdown, nil
+}
+
+// startPhaseSpan starts the span of phase, returning the context to run it with and a function that ends the span
+// with whether the phase passed.
+func startPhaseSpan(ctx context.Context, phase string) (context.Context, func(error)) {
+	ctx, span := phaseTracer(ctx).Start(ctx, "phase "+phase, trace.WithAttributes(attribute.String("ci.phase", phase)))
+	return ctx, func(err error) {
+		span.SetAttributes(attribute.Bool("ci.phase.passed", err == nil))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// sdkTestsCrate is the workspace crate with the SDK integration tests, which need localnet and so aren't part of the
+// unit tests.
+const sdkTestsCrate = "recall_sdk_tests"
+
+// cargoMetadata is the part of the `cargo metadata` output that TestChanged needs.
+type cargoMetadata struct {
+	Packages []struct {
+		Id           string `json:"id"`
+		Name         string `json:"name"`
+		ManifestPath string `json:"manifest_path"`
+	} `json:"packages"`
+	WorkspaceMembers []string `json:"workspace_members"`
+	WorkspaceRoot    string   `json:"workspace_root"`
+	Resolve          *struct {
+		Nodes []struct {
+			Id   string `json:"id"`
+			Deps []struct {
+				Pkg string `json:"pkg"`
+			} `json:"deps"`
+		} `json:"nodes"`
+	} `json:"resolve"`
+}
+
+// isWorkspaceFile reports whether file, relative to the workspace root, configures the whole workspace rather than
+// one crate, so that changing it can affect any of them.
+func isWorkspaceFile(file string) bool {
+	switch file {
+	case "Cargo.toml", "Cargo.lock", "rust-toolchain.toml", "Makefile":
+		return true
+	}
+	return strings.HasPrefix(file, ".cargo/")
+}
+
+// affectedCrates returns the workspace crates that changed files, relative to the workspace root, belong to, along
+// with the workspace crates that depend on them, sorted by name. It returns a reason to test the whole workspace
+// instead when a file that configures the whole workspace changed or the dependency graph is missing. Files outside
+// the crates, like docs and the CI module, don't affect any crate.
+func affectedCrates(metadata cargoMetadata, files []string) (crates []string, wholeWorkspace string) {
+	if metadata.Resolve == nil {
+		return nil, "cargo metadata has no dependency graph"
+	}
+	names := make(map[string]string)
+	dirs := make(map[string]string)
+	for _, pkg := range metadata.Packages {
+		if !slices.Contains(metadata.WorkspaceMembers, pkg.Id) {
+			continue
+		}
+		names[pkg.Id] = pkg.Name
+		dirs[pkg.Id] = strings.TrimPrefix(path.Dir(pkg.ManifestPath), strings.TrimSuffix(metadata.WorkspaceRoot, "/")+"/")
+	}
+
+	affected := make(map[string]bool)
+	for _, file := range files {
+		if isWorkspaceFile(file) {
+			return nil, file + " changed"
+		}
+		// The crate whose directory is the longest prefix of the file owns it, since crates can be nested
+		owner := ""
+		for id, dir := range dirs {
+			if strings.HasPrefix(file, dir+"/") && len(dir) > len(dirs[owner]) {
+				owner = id
+			}
+		}
+		if owner != "" {
+			affected[owner] = true
+		}
+	}
+
+	dependents := make(map[string][]string)
+	for _, node := range metadata.Resolve.Nodes {
+		if _, ok := names[node.Id]; !ok {
+			continue
+		}
+		for _, dep := range node.Deps {
+			dependents[dep.Pkg] = append(dependents[dep.Pkg], node.Id)
+		}
+	}
+	queue := make([]string, 0, len(affected))
+	for id := range affected {
+		queue = append(queue, id)
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, dependent := range dependents[id] {
+			if !affected[dependent] {
+				affected[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	for id := range affected {
+		crates = append(crates, names[id])
+	}
+	slices.Sort(crates)
+	return crates, ""
+}
+
+// TestChanged runs the unit tests of only the workspace crates that changed since baseRef, and of the crates that
+// depend on them, for quicker feedback on targeted changes. The whole workspace is tested when a file that configures
+// it changed, like Cargo.toml or Cargo.lock, or when the dependency graph can't be resolved. The SDK integration tests
+// need localnet and are left to Test. The sources must include the .git directory, with baseRef in its history.
+func (m *Ci) TestChanged(
+	ctx context.Context,
+	// Ref to find the changes since, from where it branched off HEAD
+	// +optional
+	// +default="origin/main"
+	baseRef string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	// The ref is passed to git as an argument, where a leading dash would make it an option
+	if baseRef == "" || strings.HasPrefix(baseRef, "-") {
+		return "", fmt.Errorf("invalid git ref %q", baseRef)
+	}
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	source = source.WithoutDirectory("target")
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{})
+	if err != nil {
+		return "", err
+	}
+	container := m.rustContainer(containerWithAuth, opts).
+		WithDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec([]string{"git", "config", "--global", "--add", "safe.directory", "/src"})
+
+	diff, err := container.
+		// Uncommitted changes count too, so the diff is against the working tree
+		WithExec([]string{"sh", "-c", `git diff --name-only "$(git merge-base "$0" HEAD)" --`, baseRef}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("list the files changed since %s, is it in the history of the sources? %w", baseRef, err)
+	}
+	files := strings.Fields(diff)
+
+	var crates []string
+	wholeWorkspace := ""
+	out, err := container.WithExec([]string{"cargo", "metadata", "--locked", "--format-version", "1"}).Stdout(ctx)
+	if err != nil {
+		wholeWorkspace = "the dependency graph can't be resolved: " + err.Error()
+	} else {
+		var metadata cargoMetadata
+		if err := json.Unmarshal([]byte(out), &metadata); err != nil {
+			wholeWorkspace = "the dependency graph can't be parsed: " + err.Error()
+		} else {
+			crates, wholeWorkspace = affectedCrates(metadata, files)
+		}
+	}
+
+	var header string
+	args := []string{"make", "test"}
+	switch {
+	case wholeWorkspace != "":
+		warnf("testing the whole workspace, %s", wholeWorkspace)
+		header = "testing the whole workspace, " + wholeWorkspace + "\n"
+	default:
+		crates = slices.DeleteFunc(crates, func(crate string) bool { return crate == sdkTestsCrate })
+		if len(crates) == 0 {
+			return fmt.Sprintf("no workspace crate changed since %s, nothing to test\n", baseRef), nil
+		}
+		header = "testing " + strings.Join(crates, ", ") + "\n"
+		args = []string{"cargo", "test", "--locked"}
+		for _, crate := range crates {
+			args = append(args, "-p", crate)
+		}
+	}
+	infof("%s", strings.TrimSpace(header))
+	stdout, err := container.WithExec(args).Stdout(ctx)
+	if err != nil {
+		return header, fmt.Errorf("test the changed crates: %w", err)
+	}
+	return header + stdout, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// testCargoMetadata is a workspace where sdk depends on provider, which depends on signer, cli depends on sdk, and
+// provider/macros is a crate nested in the directory of provider that nothing depends on.
+const testCargoMetadata = `{
+	"packages": [
+		{"id": "signer", "name": "recall_signer", "manifest_path": "/src/signer/Cargo.toml"},
+		{"id": "provider", "name": "recall_provider", "manifest_path": "/src/provider/Cargo.toml"},
+		{"id": "macros", "name": "recall_macros", "manifest_path": "/src/provider/macros/Cargo.toml"},
+		{"id": "sdk", "name": "recall_sdk", "manifest_path": "/src/sdk/Cargo.toml"},
+		{"id": "cli", "name": "recall_cli", "manifest_path": "/src/cli/Cargo.toml"},
+		{"id": "serde", "name": "serde", "manifest_path": "/root/.cargo/registry/serde/Cargo.toml"}
+	],
+	"workspace_members": ["signer", "provider", "macros", "sdk", "cli"],
+	"workspace_root": "/src",
+	"resolve": {
+		"nodes": [
+			{"id": "signer", "deps": [{"pkg": "serde"}]},
+			{"id": "provider", "deps": [{"pkg": "signer"}]},
+			{"id": "macros", "deps": []},
+			{"id": "sdk", "deps": [{"pkg": "provider"}, {"pkg": "serde"}]},
+			{"id": "cli", "deps": [{"pkg": "sdk"}]},
+			{"id": "serde", "deps": []}
+		]
+	}
+}`
+
+func TestAffectedCrates(t *testing.T) {
+	var metadata cargoMetadata
+	if err := json.Unmarshal([]byte(testCargoMetadata), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	noResolve := metadata
+	noResolve.Resolve = nil
+
+	tests := []struct {
+		name               string
+		metadata           cargoMetadata
+		files              []string
+		want               []string
+		wantWholeWorkspace bool
+	}{
+		{name: "no changes", metadata: metadata, files: nil, want: nil},
+		{name: "leaf pulls in its dependents", metadata: metadata, files: []string{"signer/src/lib.rs"},
+			want: []string{"recall_cli", "recall_provider", "recall_sdk", "recall_signer"}},
+		{name: "top of the graph", metadata: metadata, files: []string{"cli/src/main.rs"}, want: []string{"recall_cli"}},
+		{name: "nested crate owns its files", metadata: metadata, files: []string{"provider/macros/src/lib.rs"},
+			want: []string{"recall_macros"}},
+		{name: "outer crate owns the rest", metadata: metadata, files: []string{"provider/src/macros.rs"},
+			want: []string{"recall_cli", "recall_provider", "recall_sdk"}},
+		{name: "crate manifest", metadata: metadata, files: []string{"sdk/Cargo.toml"},
+			want: []string{"recall_cli", "recall_sdk"}},
+		{name: "directory name prefix", metadata: metadata, files: []string{"sdk-docs/README.md"}, want: nil},
+		{name: "docs only", metadata: metadata, files: []string{"README.md", "docs/usage.md", "dagger/ci/main.go"},
+			want: nil},
+		{name: "several crates", metadata: metadata, files: []string{"README.md", "sdk/src/lib.rs", "provider/macros/x.rs"},
+			want: []string{"recall_cli", "recall_macros", "recall_sdk"}},
+		{name: "Cargo.lock", metadata: metadata, files: []string{"sdk/src/lib.rs", "Cargo.lock"},
+			wantWholeWorkspace: true},
+		{name: "cargo config", metadata: metadata, files: []string{".cargo/config.toml"}, wantWholeWorkspace: true},
+		{name: "workspace manifest", metadata: metadata, files: []string{"Cargo.toml"}, wantWholeWorkspace: true},
+		{name: "no dependency graph", metadata: noResolve, files: []string{"docs/usage.md"}, wantWholeWorkspace: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, wholeWorkspace := affectedCrates(tt.metadata, tt.files)
+			if (wholeWorkspace != "") != tt.wantWholeWorkspace {
+				t.Fatalf("affectedCrates(%q) wholeWorkspace = %q, want it %v", tt.files, wholeWorkspace,
+					tt.wantWholeWorkspace)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("affectedCrates(%q) = %q, want %q", tt.files, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+	"golang.org/x/sync/errgroup"
+)
+
+// TestMatrix runs the SDK and CLI integration suites against each of the given localnet images, e.g. the current one
+// and a release candidate, each with its own localnet service. Up to maxParallel images are tested at once, the rest
+// wait for a free slot. It returns a result per image and fails naming each image whose suites failed.
+func (m *Ci) TestMatrix(
+	ctx context.Context,
+	// Localnet images to test against
+	localnetImages []string,
+	// How many images to test at once, defaults to the number of CPUs
+	// +optional
+	maxParallel int,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*TestMatrixResult, error) {
+	if len(localnetImages) == 0 {
+		return nil, fmt.Errorf("no localnet images to test against")
+	}
+	if maxParallel <= 0 {
+		maxParallel = runtime.NumCPU()
+	}
+
+	start := time.Now()
+	result := &TestMatrixResult{
+		Images:      make([]*ImageTestResult, len(localnetImages)),
+		Parallelism: min(maxParallel, len(localnetImages)),
+	}
+	// A failing image doesn't stop the others, so the group is only used to limit how many run at once
+	var g errgroup.Group
+	g.SetLimit(maxParallel)
+	for i, image := range localnetImages {
+		g.Go(func() error {
+			result.Images[i] = m.testImage(ctx, image, registry, dockerUsername, dockerPassword, source)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	var errs []error
+	for _, image := range result.Images {
+		if !image.Passed {
+			errs = append(errs, fmt.Errorf("localnet image %s: %s", image.Image, image.Error))
+		}
+	}
+	result.Passed = len(errs) == 0
+	return result, errors.Join(errs...)
+}
+
+// testImage runs the integration suites against a localnet started from image.
+func (m *Ci) testImage(
+	ctx context.Context,
+	image string,
+	registry string,
+	dockerUsername string,
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) *ImageTestResult {
+	result := &ImageTestResult{Image: image}
+	accounts, err := newTestAccountPicker(0, "", false, twoNodeTopology, 1)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	codeContainer, localnet, err := m.localnetSetup(
+		ctx, image, registry, dockerUsername, dockerPassword, source, localnetOpts{},
+		codeContainerOpts{accounts: accounts},
+	)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		result.Error = withLocalnetLogs(ctx, codeContainer, err).Error()
+		return result
+	}
+	suites := m.runIntegrationSuites(ctx, codeContainer, []integrationSuite{
+		{name: "sdk", cmd: "make run-sdk-tests"},
+		{name: "cli", cmd: "make run-cli-tests"},
+	}, accounts, 0, 0)
+	result.Sdk, result.Cli = suites[0].phaseResult(), suites[1].phaseResult()
+	if err := errors.Join(suites[0].err, suites[1].err); err != nil {
+		result.Error = withLocalnetLogs(ctx, codeContainer, err).Error()
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// TestMatrixResult is the report of a TestMatrix run.
+type TestMatrixResult struct {
+	// Whether the suites passed against every image
+	Passed bool
+	// Results in the order the images were given
+	Images []*ImageTestResult
+	// How many images were tested at once
+	Parallelism int
+	// Wall time of the whole run, as a Go duration (e.g. "4m30s")
+	Duration string
+}
+
+// ImageTestResult is the outcome of the integration suites against one localnet image.
+type ImageTestResult struct {
+	Image  string
+	Passed bool
+	// Phases that didn't get to run, e.g. because localnet didn't start, are nil
+	Sdk *PhaseResult
+	Cli *PhaseResult
+	// Why the suites failed against the image
+	Error string
+}
+
+// String returns a summary line per image followed by the output of its suites.
+func (r *TestMatrixResult) String() string {
+	var summary, output strings.Builder
+	for _, image := range r.Images {
+		status := "passed"
+		if !image.Passed {
+			status = "failed"
+		}
+		fmt.Fprintf(&summary, "%s %s\n", image.Image, status)
+		for _, suite := range []struct {
+			name   string
+			result *PhaseResult
+		}{{"sdk", image.Sdk}, {"cli", image.Cli}} {
+			if suite.result != nil {
+				output.WriteString(prefixLines("["+image.Image+" "+suite.name+"] ", suite.result.Stdout))
+			}
+		}
+	}
+	fmt.Fprintf(&summary, "%d images, %d at a time, in %s\n", len(r.Images), r.Parallelism, r.Duration)
+	return summary.String() + output.String()
+}
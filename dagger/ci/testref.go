@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// abbreviatedCommitPattern matches a commit hash shorter than the full 40 hex characters.
+var abbreviatedCommitPattern = regexp.MustCompile(`^[0-9a-f]{4,39}$`)
+
+// TestRef runs Test against a ref of a git repository instead of a local directory, so that a commit, tag, branch or
+// pull request can be tested, or a regression bisected, from the repository URL alone. The ref is fetched without
+// history, which is why commits must be given as their full hash; submodules are checked out with it.
+func (m *Ci) TestRef(
+	ctx context.Context,
+	// URL of the repository, e.g. https://github.com/recallnet/rust-recall
+	repoUrl string,
+	// Branch, tag, full commit hash or ref to test, e.g. refs/pull/123/head
+	ref string,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// Token for fetching a private repository over HTTPS, also used for its private git dependencies
+	// +optional
+	gitToken *dagger.Secret,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+) (*TestResult, error) {
+	if repoUrl == "" || strings.HasPrefix(repoUrl, "-") {
+		return nil, fmt.Errorf("invalid repository URL %q", repoUrl)
+	}
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid ref %q", ref)
+	}
+	if abbreviatedCommitPattern.MatchString(ref) {
+		return nil, fmt.Errorf("ref %q looks like an abbreviated commit hash, which can't be resolved without the "+
+			"history, pass the full 40 character hash", ref)
+	}
+
+	repo := dag.Git(repoUrl)
+	if gitToken != nil {
+		repo = repo.WithAuthToken(gitToken)
+	}
+	gitRef := repo.Ref(ref)
+	// Resolving the commit first fails fast, with a clear error, on a ref that doesn't exist
+	commit, err := gitRef.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s in %s: %w", ref, repoUrl, err)
+	}
+	infof("testing %s at %s (%s)", repoUrl, ref, commit)
+
+	return m.test(ctx, testOpts{
+		localnetImage:             localnetImage,
+		registry:                  registry,
+		dockerUsername:            dockerUsername,
+		dockerPassword:            dockerPassword,
+		gitToken:                  gitToken,
+		dumpLocalnetLogsOnFailure: true,
+		source:                    gitRef.Tree(),
+	})
+}
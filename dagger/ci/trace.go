@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/ci/internal/dagger"
+)
+
+// rpcProxyImage is the image of the proxy that records the RPC traffic of Trace.
+const rpcProxyImage = "mitmproxy/mitmproxy:11.0.2"
+
+// rpcTraceDir is where the proxy writes its capture, in a cache volume so that it can be read back while the proxy
+// runs.
+const rpcTraceDir = "/var/log/rpc-trace"
+
+var rpcTraceCache = dag.CacheVolume("rpc-trace")
+
+// Trace runs an integration suite, optionally limited to the tests matching testFilter, with every request to the
+// localnet endpoints routed through a recording proxy, and returns the captured requests and responses with their
+// headers and bodies. This shows exactly what the SDK and CLI send and get back, e.g. to debug unexpected results.
+// The capture is returned even when the suite fails, since that is when it is most useful.
+func (m *Ci) Trace(
+	ctx context.Context,
+	// Only run tests whose name contains this string
+	// +optional
+	testFilter string,
+	// Suite to run, sdk (the default) or cli
+	// +optional
+	suite string,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.File, error) {
+	if suite == "" {
+		suite = "sdk"
+	}
+	cmd, ok := map[string]string{"sdk": "make run-sdk-tests", "cli": "make run-cli-tests"}[suite]
+	if !ok {
+		return nil, fmt.Errorf("unknown suite %q, expected sdk or cli", suite)
+	}
+	containerWithAuth, err := m.getContainerWithAuth("", registry, dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	codeContainer, localnet, err := m.localnetSetup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{}, codeContainerOpts{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return nil, withLocalnetLogs(ctx, codeContainer, err)
+	}
+
+	networksToml, err := codeContainer.File("/root/.config/recall/networks.toml").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseNetworksToml(networksToml)
+	if err != nil {
+		return nil, err
+	}
+	traceFile := rpcTraceDir + "/" + strconv.FormatInt(time.Now().UnixNano(), 36) + ".log"
+	proxy, err := rpcProxy(containerWithAuth, localnet, config, traceFile)
+	if err != nil {
+		return nil, err
+	}
+	proxiedConfig, err := config.withHost("rpc-proxy", localnetPorts{})
+	if err != nil {
+		return nil, err
+	}
+
+	_, testErr := codeContainer.
+		WithServiceBinding("rpc-proxy", proxy).
+		WithNewFile("/root/.config/recall/networks.toml", renderNetworksToml("localnet", proxiedConfig)).
+		WithEnvVariable(networkRpcUrlEnv, proxiedConfig.RpcUrl).
+		WithEnvVariable("TEST_FILTER", testFilter).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", cmd}).
+		Sync(ctx)
+	if testErr != nil {
+		if ctx.Err() != nil {
+			return nil, testErr
+		}
+		warnf("the %s tests failed, returning the capture of the run: %v", suite, testErr)
+	}
+
+	trace, err := codeContainer.
+		WithMountedCache(rpcTraceDir, rpcTraceCache).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", `cp "$0" /tmp/rpc-trace.log`, traceFile}).
+		File("/tmp/rpc-trace.log").
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read the RPC capture: %w", err)
+	}
+	return trace, nil
+}
+
+// rpcProxy returns a service called rpc-proxy that forwards each of the localnet endpoints in config from the same
+// port to localnet, writing every request and response to traceFile.
+func rpcProxy(
+	containerWithAuth *dagger.Container,
+	localnet *dagger.Service,
+	config NetworkConfig,
+	traceFile string,
+) (*dagger.Service, error) {
+	proxy := containerWithAuth.
+		From(rpcProxyImage).
+		WithServiceBinding("localnet", localnet).
+		WithMountedCache(rpcTraceDir, rpcTraceCache)
+	ports := config.ports()
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("the localnet network config has no endpoints to trace")
+	}
+	args := []string{"mitmdump", "--flow-detail", "4"}
+	for _, port := range ports {
+		proxy = proxy.WithExposedPort(port.port)
+		args = append(args, "--mode", fmt.Sprintf("reverse:http://localnet:%d@%d", port.port, port.port))
+	}
+	return proxy.
+		AsService(dagger.ContainerAsServiceOpts{
+			Args: []string{"sh", "-c", strings.Join(args, " ") + ` 2>&1 | tee "$0"`, traceFile},
+		}).
+		WithHostname("rpc-proxy"), nil
+}
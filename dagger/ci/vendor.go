@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/ci/internal/dagger"
+)
+
+// vendorCargoConfig is where Vendor saves the source replacement config printed by `cargo vendor`. Cargo ignores
+// dot directories in a vendor directory, so it can be kept alongside the crates.
+const vendorCargoConfig = ".cargo/config.toml"
+
+// Vendor downloads the sources of all the workspace's dependencies with `cargo vendor` and returns them, so that they
+// can be committed or cached ahead of time and passed as vendor to Test for an offline build. The returned directory
+// includes the cargo config that replaces the crates.io and git sources with it, which expects it at /vendor.
+func (m *Ci) Vendor(
+	ctx context.Context,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+	vendor := m.rustContainer(containerWithAuth, codeContainerOpts{}).
+		WithDirectory("/src", filterSource(source)).
+		WithWorkdir("/src").
+		WithExec([]string{
+			"sh", "-c",
+			"cargo vendor --locked /vendor > /tmp/config.toml && " +
+				"mkdir -p /vendor/.cargo && mv /tmp/config.toml /vendor/" + vendorCargoConfig,
+		}).
+		Directory("/vendor")
+	if _, err := vendor.Sync(ctx); err != nil {
+		return nil, fmt.Errorf("vendor dependencies: %w", err)
+	}
+	return vendor, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+	"golang.org/x/sync/errgroup"
+)
+
+// reproducibleBuildCmd builds the release binary from scratch in its own target directory, with the modification
+// times of the sources normalized to SOURCE_DATE_EPOCH so that nothing that embeds them depends on when the sources
+// were copied.
+const reproducibleBuildCmd = `find . -path ./target -prune -o -exec touch -h -d "@$SOURCE_DATE_EPOCH" {} + && ` +
+	`cargo build --locked --release --bin recall`
+
+// sectionDiffCmd prints the name and size of every section that differs between /a/recall and /b/recall, and nothing
+// when they all match.
+const sectionDiffCmd = `for section in $(readelf -SW /a/recall | sed -n 's/^ *\[ *[0-9]*\] \([^ ]*\) .*/\1/p'); do
+  objcopy -O binary --only-section="$section" /a/recall /tmp/a.section
+  objcopy -O binary --only-section="$section" /b/recall /tmp/b.section
+  cmp -s /tmp/a.section /tmp/b.section ||
+    echo "$section: $(stat -c %s /tmp/a.section) and $(stat -c %s /tmp/b.section) bytes"
+done`
+
+// Verify checks that the release build is reproducible: it builds the `recall` binary twice, each from scratch in an
+// isolated container with SOURCE_DATE_EPOCH set, and returns the sha256 of the binary when both builds are
+// byte-identical. Otherwise it fails, listing the ELF sections that differ.
+func (m *Ci) Verify(
+	ctx context.Context,
+	// SOURCE_DATE_EPOCH for the builds, which the source timestamps are normalized to
+	// +optional
+	sourceDateEpoch int,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (string, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return "", err
+	}
+	source = filterSource(source)
+
+	var binaries [2]*dagger.File
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i := range binaries {
+		eg.Go(func() error {
+			// Build outside of the target cache volume so that neither build reuses artifacts, and make the builds
+			// distinct so that Dagger doesn't run only one of them
+			binary, err := m.rustContainer(containerWithAuth, codeContainerOpts{}).
+				WithEnvVariable("SOURCE_DATE_EPOCH", strconv.Itoa(sourceDateEpoch)).
+				WithEnvVariable("CARGO_TARGET_DIR", "/build/target").
+				WithEnvVariable("REPRODUCIBLE_BUILD", strconv.Itoa(i+1)).
+				WithDirectory("/src", source).
+				WithWorkdir("/src").
+				WithExec([]string{"sh", "-c", reproducibleBuildCmd}).
+				File("/build/target/release/recall").
+				Sync(egCtx)
+			if err != nil {
+				return fmt.Errorf("build %d: %w", i+1, err)
+			}
+			binaries[i] = binary
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return "", err
+	}
+
+	compare := containerWithAuth.
+		From("debian:bookworm-slim").
+		WithExec([]string{"apt-get", "update"}).
+		WithExec([]string{"apt-get", "install", "-y", "--no-install-recommends", "binutils"}).
+		WithFile("/a/recall", binaries[0]).
+		WithFile("/b/recall", binaries[1])
+	sums, err := compare.WithExec([]string{"sh", "-c", "sha256sum /a/recall /b/recall | cut -d' ' -f1"}).Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("hash binaries: %w", err)
+	}
+	hashes := strings.Fields(sums)
+	if len(hashes) != 2 {
+		return "", fmt.Errorf("unexpected sha256sum output %q", sums)
+	}
+	if hashes[0] == hashes[1] {
+		infof("release build is reproducible, sha256 %s", hashes[0])
+		return hashes[0], nil
+	}
+	sections, err := compare.WithExec([]string{"sh", "-c", sectionDiffCmd}).Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("compare binary sections: %w", err)
+	}
+	if strings.TrimSpace(sections) == "" {
+		sections = "no section differs, only the ELF headers or the layout around the sections\n"
+	}
+	return "", fmt.Errorf("release build is not reproducible, the binaries have sha256 %s and %s, differing in:\n%s",
+		hashes[0], hashes[1], sections)
+}
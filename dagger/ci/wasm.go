@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/ci/internal/dagger"
+)
+
+// wasmTarget is the target the SDK is built for to be consumed from JavaScript.
+const wasmTarget = "wasm32-unknown-unknown"
+
+// cargoCompileErrorPattern matches cargo's summary line for a crate that failed to compile, with the crate name as the
+// submatch.
+var cargoCompileErrorPattern = regexp.MustCompile("(?m)^error: could not compile `([^`]+)`")
+
+// BuildWasm builds the SDK for wasm32-unknown-unknown with wasm-pack and returns the generated pkg directory. The
+// sources are first compiled for the WASM target with cargo, so that a dependency that doesn't support WASM fails
+// with the crates that broke. wasm-pack requires the SDK crate to be a cdylib that depends on wasm-bindgen.
+func (m *Ci) BuildWasm(
+	ctx context.Context,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Directory, error) {
+	containerWithAuth, err := m.getContainerWithAuth("", "", dockerUsername, dockerPassword)
+	if err != nil {
+		return nil, err
+	}
+	source = filterSource(source)
+	opts, err := keyTargetCache(ctx, source, codeContainerOpts{cargoTools: []string{"wasm-pack"}})
+	if err != nil {
+		return nil, err
+	}
+	container := m.rustContainer(containerWithAuth, opts).
+		WithExec([]string{"rustup", "target", "add", wasmTarget}).
+		WithDirectory("/src", source).
+		WithWorkdir("/src")
+
+	check := container.WithExec(
+		[]string{"sh", "-c", "cargo build --locked -p recall_sdk --target " + wasmTarget + " 2>&1"},
+		dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+	)
+	exitCode, err := check.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode != 0 {
+		output, err := check.Stdout(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return nil, wasmBuildError(output)
+	}
+
+	// The target directory is a cache volume, so the package is generated outside of it
+	pkg, err := container.
+		WithExec([]string{"wasm-pack", "build", "sdk", "--release", "--target", "web", "--out-dir", "/pkg"}).
+		Directory("/pkg").
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("wasm-pack build: %w", err)
+	}
+	return pkg, nil
+}
+
+// wasmBuildError describes a failed cargo build for the WASM target, naming the crates that failed to compile and
+// including the compiler's errors.
+func wasmBuildError(output string) error {
+	var crates []string
+	for _, match := range cargoCompileErrorPattern.FindAllStringSubmatch(output, -1) {
+		crates = append(crates, match[1])
+	}
+	var errorLines []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "error") {
+			errorLines = append(errorLines, line)
+		}
+	}
+	if len(crates) == 0 {
+		return fmt.Errorf("SDK does not build for %s:\n%s", wasmTarget, output)
+	}
+	return fmt.Errorf(
+		"SDK does not build for %s, crates that failed to compile: %s\n%s",
+		wasmTarget, strings.Join(crates, ", "), strings.Join(errorLines, "\n"),
+	)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"dagger/ci/internal/dagger"
+)
+
+// Watch runs the workspace tests, optionally limited to the tests matching testFilter, with cargo-watch in an
+// interactive terminal on the code container, re-running them whenever a file under /src changes. Localnet is bound
+// once it produces blocks and keeps running across the re-runs until the session ends, so the SDK integration tests
+// are included. The sources are a snapshot taken when the call starts, so only changes made within the session are
+// picked up. The container is returned after the session ends, so that it can be chained further.
+func (m *Ci) Watch(
+	ctx context.Context,
+	// Only run tests whose name contains this string
+	// +optional
+	testFilter string,
+	// +optional
+	localnetImage string,
+	// Registry to pull images from and authenticate against, e.g. a mirror, defaults to docker.io
+	// +optional
+	registry string,
+	// +optional
+	dockerUsername string,
+	// +optional
+	dockerPassword *dagger.Secret,
+	source *dagger.Directory,
+) (*dagger.Container, error) {
+	codeContainer, localnet, err := m.localnetSetup(
+		ctx, localnetImage, registry, dockerUsername, dockerPassword, source, localnetOpts{},
+		codeContainerOpts{cargoTools: []string{"cargo-watch"}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.waitForLocalnet(ctx, codeContainer, localnet, defaultLocalnetTimeout, defaultMinHeightDelta); err != nil {
+		return nil, withLocalnetLogs(ctx, codeContainer, err)
+	}
+	return codeContainer.
+		// The filter is passed through the environment, like for the make targets, so that it is never parsed as
+		// part of the command
+		WithEnvVariable("TEST_FILTER", testFilter).
+		Terminal(dagger.ContainerTerminalOpts{
+			Cmd: []string{"cargo", "watch", "--why", "--ignore", "target", "-s", "make run-all-tests"},
+		}), nil
+}
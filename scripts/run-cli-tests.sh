@@ -11,6 +11,9 @@ export RECALL_CLI
 export RECALL_PRIVATE_KEY
 
 for file in $(find tests/cli -type f | sort); do
+    if [[ -n "$TEST_FILTER" && "$(basename "$file")" != *"$TEST_FILTER"* ]]; then
+        continue
+    fi
     echo "Running test: $file"
     chmod +x "$file"
 
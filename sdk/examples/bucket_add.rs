@@ -12,7 +12,7 @@ use tokio::time::{sleep, Duration};
 use recall_provider::json_rpc::JsonRpcProvider;
 use recall_sdk::{
     machine::{
-        bucket::{AddOptions, Bucket, GetOptions, QueryOptions},
+        bucket::{AddOptions, Bucket, GetOptions, ObjectRange, QueryOptions},
         Machine,
     },
     network::Network,
@@ -103,7 +103,7 @@ async fn main() -> anyhow::Result<()> {
     let obj_path = obj_file.file_path().to_owned();
     println!("Downloading object to {}", obj_path.display());
     let options = GetOptions {
-        range: Some("0-99".to_string()), // Get the first 100 bytes
+        range: Some(ObjectRange::Bounded { start: 0, end: 99 }), // Get the first 100 bytes
         ..Default::default()
     };
     {
@@ -1,11 +1,16 @@
 // Copyright 2025 Recall Contributors
 // SPDX-License-Identifier: Apache-2.0, MIT
 
+use std::fmt::{self, Display, Formatter};
+use std::ops::{Range, RangeFrom, RangeInclusive};
 use std::path::Path;
-use std::{cmp::min, collections::HashMap, str::FromStr};
+use std::pin::Pin;
+use std::task::{Context, Poll};
+use std::{collections::HashMap, str::FromStr};
 
 use anyhow::anyhow;
 use async_trait::async_trait;
+use bytes::Bytes;
 use fendermint_actor_blobs_shared::bytes::B256;
 use fendermint_actor_bucket::{
     AddParams, DeleteParams, GetParams, ListObjectsReturn, ListParams,
@@ -28,10 +33,11 @@ use recall_provider::{
     Client, Provider,
 };
 use recall_signer::Signer;
+use reqwest::{header::CONTENT_RANGE, StatusCode};
 use tendermint::abci::response::DeliverTx;
 use tokio::io::{AsyncRead, AsyncSeekExt, AsyncWrite, AsyncWriteExt};
 use tokio::time::Instant;
-use tokio_stream::StreamExt;
+use tokio_stream::{Stream, StreamExt};
 use tokio_util::io::ReaderStream;
 
 pub use fendermint_actor_bucket::{Object, ObjectState};
@@ -88,17 +94,157 @@ pub struct UpdateObjectMetadataOptions {
 #[derive(Clone, Default, Debug)]
 pub struct GetOptions {
     /// Optional range of bytes to get from the object.
-    /// Format: "start-end" (inclusive).
-    /// Example: "0-99" (first 100 bytes).
-    /// This follows the HTTP range header format:
-    /// `<https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Range>`
-    pub range: Option<String>,
+    /// The download fails if the object API doesn't return exactly this range.
+    pub range: Option<ObjectRange>,
     /// Query block height.
     pub height: FvmQueryHeight,
     /// Whether to show progress-related output (useful for command-line interfaces).
     pub show_progress: bool,
 }
 
+/// A range of bytes of an object.
+///
+/// This follows the HTTP range header format, and parses from and displays as its
+/// "start-end" (inclusive), "start-", and "-length" forms:
+/// `<https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Range>`
+#[derive(Clone, Copy, Debug, PartialEq, Eq)]
+pub enum ObjectRange {
+    /// Bytes from `start` to `end`, inclusive, e.g., "0-99" (first 100 bytes).
+    /// `end` may be past the end of the object.
+    Bounded { start: u64, end: u64 },
+    /// Bytes from `start` to the end of the object, e.g., "1024-".
+    AllFrom(u64),
+    /// The last bytes of the object, e.g., "-512" (last 512 bytes).
+    Suffix(u64),
+}
+
+impl ObjectRange {
+    /// Returns the inclusive start and end of the range within an object of `size` bytes,
+    /// or `None` if none of its bytes are in the object.
+    fn window(&self, size: u64) -> Option<(u64, u64)> {
+        let (start, end) = match *self {
+            ObjectRange::Bounded { start, end } => (start, end.min(size.checked_sub(1)?)),
+            ObjectRange::AllFrom(start) => (start, size.checked_sub(1)?),
+            ObjectRange::Suffix(length) => (size.saturating_sub(length), size.checked_sub(1)?),
+        };
+        (start <= end).then_some((start, end))
+    }
+
+    /// Checks that the range isn't empty.
+    fn validate(&self) -> anyhow::Result<()> {
+        match *self {
+            ObjectRange::Bounded { start, end } if start > end => Err(anyhow!(
+                "invalid range '{}', start must not be greater than end",
+                self
+            )),
+            ObjectRange::Suffix(0) => {
+                Err(anyhow!("invalid range '{}', length must be positive", self))
+            }
+            _ => Ok(()),
+        }
+    }
+}
+
+impl FromStr for ObjectRange {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        let invalid = || anyhow!("invalid range '{}', expected e.g. 0-99, 1024-, or -512", s);
+        let parse = |v: &str| v.parse::<u64>().map_err(|_| invalid());
+        let range = match s.split_once('-').ok_or_else(invalid)? {
+            ("", "") => return Err(invalid()),
+            ("", length) => ObjectRange::Suffix(parse(length)?),
+            (start, "") => ObjectRange::AllFrom(parse(start)?),
+            (start, end) => ObjectRange::Bounded {
+                start: parse(start)?,
+                end: parse(end)?,
+            },
+        };
+        range.validate()?;
+        Ok(range)
+    }
+}
+
+impl Display for ObjectRange {
+    fn fmt(&self, f: &mut Formatter<'_>) -> fmt::Result {
+        match self {
+            ObjectRange::Bounded { start, end } => write!(f, "{}-{}", start, end),
+            ObjectRange::AllFrom(start) => write!(f, "{}-", start),
+            ObjectRange::Suffix(length) => write!(f, "-{}", length),
+        }
+    }
+}
+
+impl From<RangeInclusive<u64>> for ObjectRange {
+    fn from(range: RangeInclusive<u64>) -> Self {
+        ObjectRange::Bounded {
+            start: *range.start(),
+            end: *range.end(),
+        }
+    }
+}
+
+impl From<RangeFrom<u64>> for ObjectRange {
+    fn from(range: RangeFrom<u64>) -> Self {
+        ObjectRange::AllFrom(range.start)
+    }
+}
+
+/// Converts an exclusive range, e.g., `0..100` for the first 100 bytes.
+/// This fails for an empty range, which has no bytes to request.
+impl TryFrom<Range<u64>> for ObjectRange {
+    type Error = anyhow::Error;
+
+    fn try_from(range: Range<u64>) -> Result<Self, Self::Error> {
+        if range.is_empty() {
+            return Err(anyhow!(
+                "invalid range {}..{}, end must be greater than start",
+                range.start,
+                range.end
+            ));
+        }
+        Ok(ObjectRange::Bounded {
+            start: range.start,
+            end: range.end - 1,
+        })
+    }
+}
+
+/// A download of an object as a stream of chunks, returned by [`Bucket::get_stream`].
+pub struct ObjectStream {
+    /// The object being downloaded.
+    pub object: Object,
+    /// Number of bytes in the download, if the object API reports it.
+    /// This is less than the object size if a range was requested.
+    pub content_length: Option<u64>,
+    inner: Pin<Box<dyn Stream<Item = reqwest::Result<Bytes>> + Send>>,
+}
+
+impl Stream for ObjectStream {
+    type Item = anyhow::Result<Bytes>;
+
+    fn poll_next(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<Option<Self::Item>> {
+        self.inner
+            .as_mut()
+            .poll_next(cx)
+            .map(|item| item.map(|chunk| chunk.map_err(|e| anyhow!(e))))
+    }
+}
+
+/// Order of the objects returned by [`Bucket::query`].
+///
+/// There is no order by when objects were added, since [`ObjectState`] doesn't record it.
+#[derive(Clone, Copy, Default, Debug, PartialEq, Eq)]
+pub enum QuerySort {
+    /// The order of the bucket's internal storage, which isn't meaningful to users.
+    #[default]
+    None,
+    /// Lexicographically by the raw key bytes.
+    Name,
+    /// By expiry epoch, with ties ordered by key.
+    Expiry,
+}
+
 /// Object query options.
 #[derive(Clone, Debug)]
 pub struct QueryOptions {
@@ -110,6 +256,12 @@ pub struct QueryOptions {
     pub start_key: Option<Vec<u8>>,
     /// The maximum number of objects to list.
     pub limit: u64,
+    /// Order of the returned objects.
+    /// The bucket can't sort server-side, so sorting applies within the returned page only,
+    /// not across pages.
+    pub sort: QuerySort,
+    /// Reverse the order of the returned objects.
+    pub reverse: bool,
     /// Query block height.
     pub height: FvmQueryHeight,
 }
@@ -121,6 +273,8 @@ impl Default for QueryOptions {
             delimiter: "/".into(),
             start_key: Default::default(),
             limit: Default::default(),
+            sort: Default::default(),
+            reverse: Default::default(),
             height: Default::default(),
         }
     }
@@ -316,7 +470,10 @@ impl Bucket {
             .await
     }
 
-    /// Get an object at the given key, range, and height.
+    /// Get an object at the given key, range, and height, writing it to `writer`.
+    ///
+    /// The object is written as it downloads, so memory use doesn't grow with its size.
+    /// Use [`Bucket::get_stream`] to consume the chunks directly.
     pub async fn get<W>(
         &self,
         provider: &(impl QueryProvider + ObjectProvider),
@@ -333,13 +490,8 @@ impl Bucket {
 
         msg_bar.set_prefix("[1/2]");
         msg_bar.set_message("Getting object info...");
-        let params = GetParams(key.into());
-        let params = RawBytes::serialize(params)?;
-        let message = local_message(self.address, GetObject as u64, params);
-        let response = provider.call(message, options.height, decode_get).await?;
-        let object = response
-            .value
-            .ok_or_else(|| anyhow!("object not found for key '{}'", key))?;
+        let mut stream = self.get_stream(provider, key, options).await?;
+        let object = stream.object.clone();
 
         msg_bar.set_prefix("[2/2]");
         msg_bar.set_message(format!(
@@ -347,25 +499,21 @@ impl Bucket {
             object.hash, object.size
         ));
 
-        let pro_bar = bars.add(new_progress_bar(object.size));
-        let response = provider
-            .download(self.address, key, options.range, options.height.into())
-            .await?;
-        let mut stream = response.bytes_stream();
-        let mut progress = 0;
-        while let Some(item) = stream.next().await {
-            match item {
-                Ok(chunk) => {
-                    writer.write_all(&chunk).await?;
-                    progress = min(progress + chunk.len(), object.size as usize);
-                    pro_bar.set_position(progress as u64);
-                }
-                Err(e) => {
-                    return Err(anyhow!(e));
-                }
+        // Without a content length there is nothing to measure progress against
+        let pro_bar = stream
+            .content_length
+            .map(|length| bars.add(new_progress_bar(length)));
+        while let Some(chunk) = stream.next().await {
+            let chunk = chunk?;
+            writer.write_all(&chunk).await?;
+            if let Some(pro_bar) = &pro_bar {
+                pro_bar.inc(chunk.len() as u64);
             }
         }
-        pro_bar.finish_and_clear();
+        writer.flush().await?;
+        if let Some(pro_bar) = &pro_bar {
+            pro_bar.finish_and_clear();
+        }
         msg_bar.println(format!(
             "{} Downloaded object in {} (hash={}; size={})",
             SPARKLE,
@@ -378,9 +526,50 @@ impl Bucket {
         Ok(())
     }
 
+    /// Get an object at the given key, range, and height as a stream of chunks.
+    ///
+    /// The chunks are read from the object API as the stream is polled,
+    /// so large objects can be processed with constant memory.
+    /// `show_progress` in [`GetOptions`] is ignored.
+    pub async fn get_stream(
+        &self,
+        provider: &(impl QueryProvider + ObjectProvider),
+        key: &str,
+        options: GetOptions,
+    ) -> anyhow::Result<ObjectStream> {
+        let params = GetParams(key.into());
+        let params = RawBytes::serialize(params)?;
+        let message = local_message(self.address, GetObject as u64, params);
+        let response = provider.call(message, options.height, decode_get).await?;
+        let object = response
+            .value
+            .ok_or_else(|| anyhow!("object not found for key '{}'", key))?;
+
+        if let Some(range) = &options.range {
+            range.validate()?;
+        }
+        let response = provider
+            .download(
+                self.address,
+                key,
+                options.range.map(|range| range.to_string()),
+                options.height.into(),
+            )
+            .await?;
+        if let Some(range) = options.range {
+            validate_range_response(range, object.size, &response)?;
+        }
+        Ok(ObjectStream {
+            object,
+            content_length: response.content_length(),
+            inner: Box::pin(response.bytes_stream()),
+        })
+    }
+
     /// Query for objects with params at the given height.
     ///
-    /// Use [`QueryOptions`] for filtering and pagination.
+    /// Use [`QueryOptions`] for filtering, pagination, and sorting.
+    /// Sorting is done on the client, within the returned page.
     pub async fn query(
         &self,
         provider: &impl QueryProvider,
@@ -395,7 +584,9 @@ impl Bucket {
         let params = RawBytes::serialize(params)?;
         let message = local_message(self.address, ListObjects as u64, params);
         let response = provider.call(message, options.height, decode_list).await?;
-        Ok(response.value)
+        let mut list = response.value;
+        sort_objects(&mut list.objects, options.sort, options.reverse);
+        Ok(list)
     }
 
     /// Update object metadata.
@@ -458,6 +649,117 @@ impl Bucket {
     }
 }
 
+/// Sorts objects by `sort`, breaking ties by key so the order is deterministic,
+/// and reverses the result if `reverse` is set.
+fn sort_objects(objects: &mut [(Vec<u8>, ObjectState)], sort: QuerySort, reverse: bool) {
+    match sort {
+        QuerySort::None => {}
+        QuerySort::Name => objects.sort_by(|(a, _), (b, _)| a.cmp(b)),
+        QuerySort::Expiry => objects.sort_by(|(a_key, a), (b_key, b)| {
+            a.expiry.cmp(&b.expiry).then_with(|| a_key.cmp(b_key))
+        }),
+    }
+    if reverse {
+        objects.reverse();
+    }
+}
+
+/// Checks that `response` holds exactly the bytes of `range` within an object of `size` bytes,
+/// rather than e.g. the whole object from a gateway that ignores the range.
+fn validate_range_response(
+    range: ObjectRange,
+    size: u64,
+    response: &reqwest::Response,
+) -> anyhow::Result<()> {
+    let content_range = response
+        .headers()
+        .get(CONTENT_RANGE)
+        .map(|value| value.to_str())
+        .transpose()?;
+    check_range_response(
+        range,
+        size,
+        response.status(),
+        content_range,
+        response.content_length(),
+    )
+}
+
+/// Checks the status, content-range header and content length of a response to a request for
+/// `range` within an object of `size` bytes.
+/// A 200 response with the whole object is only accepted if the range covers the whole object,
+/// e.g., "0-" or a suffix at least as long as the object, since servers may answer those that way.
+fn check_range_response(
+    range: ObjectRange,
+    size: u64,
+    status: StatusCode,
+    content_range: Option<&str>,
+    content_length: Option<u64>,
+) -> anyhow::Result<()> {
+    let (start, end) = range
+        .window(size)
+        .ok_or_else(|| anyhow!("range {} is outside of the object (size={})", range, size))?;
+    match status {
+        StatusCode::PARTIAL_CONTENT => {}
+        StatusCode::OK if (start, end) == (0, size - 1) => {
+            return check_content_length(content_length, size, range);
+        }
+        StatusCode::OK => {
+            return Err(anyhow!(
+                "object API returned the whole object (status {}) instead of range {}",
+                status,
+                range
+            ));
+        }
+        _ => {
+            return Err(anyhow!(
+                "object API returned status {} for range {}",
+                status,
+                range
+            ));
+        }
+    }
+    let content_range = content_range.ok_or_else(|| {
+        anyhow!(
+            "missing content-range header in response for range {}",
+            range
+        )
+    })?;
+    // Format: "bytes start-end/size", where size may be "*"
+    let window = content_range
+        .strip_prefix("bytes ")
+        .and_then(|v| v.split_once('/'))
+        .and_then(|(window, _)| window.split_once('-'))
+        .and_then(|(start, end)| Some((start.parse::<u64>().ok()?, end.parse::<u64>().ok()?)));
+    if window != Some((start, end)) {
+        return Err(anyhow!(
+            "object API returned '{}' instead of bytes {}-{} for range {}",
+            content_range,
+            start,
+            end,
+            range
+        ));
+    }
+    check_content_length(content_length, end - start + 1, range)
+}
+
+/// Checks that a response for `range` holds `expected` bytes, if it reports its length.
+fn check_content_length(
+    content_length: Option<u64>,
+    expected: u64,
+    range: ObjectRange,
+) -> anyhow::Result<()> {
+    match content_length {
+        Some(length) if length != expected => Err(anyhow!(
+            "object API returned {} bytes instead of {} for range {}",
+            length,
+            expected,
+            range
+        )),
+        _ => Ok(()),
+    }
+}
+
 fn decode_get(deliver_tx: &DeliverTx) -> anyhow::Result<Option<Object>> {
     let data = decode_bytes(deliver_tx)?;
     fvm_ipld_encoding::from_slice(&data)
@@ -511,3 +813,214 @@ fn validate_metadata_optional(metadata: &HashMap<String, Option<String>>) -> any
 
     Ok(())
 }
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashMap;
+
+    use fendermint_actor_blobs_shared::bytes::B256;
+    use reqwest::StatusCode;
+
+    use super::{check_range_response, sort_objects, ObjectRange, ObjectState, QuerySort};
+
+    #[test]
+    fn parse_object_range() {
+        for (s, range) in [
+            ("0-99", ObjectRange::Bounded { start: 0, end: 99 }),
+            ("5-5", ObjectRange::Bounded { start: 5, end: 5 }),
+            ("1024-", ObjectRange::AllFrom(1024)),
+            ("0-", ObjectRange::AllFrom(0)),
+            ("-512", ObjectRange::Suffix(512)),
+        ] {
+            assert_eq!(s.parse::<ObjectRange>().unwrap(), range, "{}", s);
+            assert_eq!(range.to_string(), s);
+        }
+        for s in [
+            "99-0",
+            "-0",
+            "18446744073709551616-",
+            "0-18446744073709551616",
+            "-18446744073709551616",
+            "",
+            "-",
+            "100",
+            "1-2-3",
+            "a-b",
+            "0x10-",
+            " 1-2",
+        ] {
+            assert!(s.parse::<ObjectRange>().is_err(), "{}", s);
+        }
+    }
+
+    #[test]
+    fn object_range_window() {
+        let bounded = |start, end| ObjectRange::Bounded { start, end };
+        assert_eq!(bounded(0, 99).window(1000), Some((0, 99)));
+        assert_eq!(bounded(10, 5000).window(1000), Some((10, 999)));
+        assert_eq!(bounded(999, 999).window(1000), Some((999, 999)));
+        assert_eq!(bounded(1000, 1100).window(1000), None);
+        assert_eq!(ObjectRange::AllFrom(0).window(1000), Some((0, 999)));
+        assert_eq!(ObjectRange::AllFrom(999).window(1000), Some((999, 999)));
+        assert_eq!(ObjectRange::AllFrom(1000).window(1000), None);
+        assert_eq!(ObjectRange::Suffix(10).window(1000), Some((990, 999)));
+        assert_eq!(ObjectRange::Suffix(5000).window(1000), Some((0, 999)));
+        assert_eq!(ObjectRange::AllFrom(0).window(0), None);
+        assert_eq!(ObjectRange::Suffix(10).window(0), None);
+    }
+
+    #[test]
+    fn validate_object_range() {
+        assert!(ObjectRange::Bounded { start: 0, end: 0 }.validate().is_ok());
+        assert!(ObjectRange::Bounded { start: 5, end: 4 }
+            .validate()
+            .is_err());
+        assert!(ObjectRange::AllFrom(0).validate().is_ok());
+        assert!(ObjectRange::Suffix(1).validate().is_ok());
+        assert!(ObjectRange::Suffix(0).validate().is_err());
+        assert!(ObjectRange::from(5..=4).validate().is_err());
+    }
+
+    #[test]
+    fn convert_object_range() {
+        assert_eq!(
+            ObjectRange::from(0..=99),
+            ObjectRange::Bounded { start: 0, end: 99 }
+        );
+        assert_eq!(ObjectRange::from(1024..), ObjectRange::AllFrom(1024));
+        assert_eq!(
+            ObjectRange::try_from(0..100).unwrap(),
+            ObjectRange::Bounded { start: 0, end: 99 }
+        );
+        assert_eq!(
+            ObjectRange::try_from(5..6).unwrap(),
+            ObjectRange::Bounded { start: 5, end: 5 }
+        );
+        assert!(ObjectRange::try_from(5..5).is_err());
+        #[allow(clippy::reversed_empty_ranges)]
+        let reversed = 6..5;
+        assert!(ObjectRange::try_from(reversed).is_err());
+    }
+
+    #[test]
+    fn check_partial_range_response() {
+        let range = ObjectRange::Bounded { start: 0, end: 99 };
+        let partial = StatusCode::PARTIAL_CONTENT;
+        let check = |range, content_range, content_length| {
+            check_range_response(range, 1000, partial, content_range, content_length)
+        };
+        assert!(check(range, Some("bytes 0-99/1000"), Some(100)).is_ok());
+        assert!(check(range, Some("bytes 0-99/*"), None).is_ok());
+        assert!(check(
+            ObjectRange::Suffix(10),
+            Some("bytes 990-999/1000"),
+            Some(10)
+        )
+        .is_ok());
+        assert!(check(
+            ObjectRange::Bounded {
+                start: 990,
+                end: 5000
+            },
+            Some("bytes 990-999/1000"),
+            Some(10)
+        )
+        .is_ok());
+        assert!(check(range, Some("bytes 0-999/1000"), Some(100)).is_err());
+        assert!(check(range, Some("bytes 1-100/1000"), Some(100)).is_err());
+        assert!(check(range, Some("0-99/1000"), Some(100)).is_err());
+        assert!(check(range, Some("bytes 0-99"), Some(100)).is_err());
+        assert!(check(range, Some("bytes */1000"), Some(100)).is_err());
+        assert!(check(range, None, Some(100)).is_err());
+        assert!(check(range, Some("bytes 0-99/1000"), Some(1000)).is_err());
+        assert!(check(ObjectRange::AllFrom(1000), Some("bytes */1000"), None).is_err());
+    }
+
+    #[test]
+    fn check_whole_object_response() {
+        let ok = StatusCode::OK;
+        for range in [
+            ObjectRange::AllFrom(0),
+            ObjectRange::Suffix(1000),
+            ObjectRange::Suffix(5000),
+            ObjectRange::Bounded { start: 0, end: 999 },
+            ObjectRange::Bounded {
+                start: 0,
+                end: 5000,
+            },
+        ] {
+            assert!(
+                check_range_response(range, 1000, ok, None, Some(1000)).is_ok(),
+                "{}",
+                range
+            );
+            assert!(
+                check_range_response(range, 1000, ok, None, None).is_ok(),
+                "{}",
+                range
+            );
+            assert!(
+                check_range_response(range, 1000, ok, None, Some(100)).is_err(),
+                "{}",
+                range
+            );
+        }
+        for range in [
+            ObjectRange::AllFrom(1),
+            ObjectRange::Suffix(999),
+            ObjectRange::Bounded { start: 0, end: 998 },
+        ] {
+            assert!(
+                check_range_response(range, 1000, ok, None, Some(1000)).is_err(),
+                "{}",
+                range
+            );
+        }
+    }
+
+    #[test]
+    fn check_other_range_response() {
+        let range = ObjectRange::AllFrom(0);
+        for status in [StatusCode::NOT_FOUND, StatusCode::RANGE_NOT_SATISFIABLE] {
+            assert!(
+                check_range_response(range, 1000, status, Some("bytes 0-999/1000"), None).is_err(),
+                "{}",
+                status
+            );
+        }
+    }
+
+    #[test]
+    fn sort_objects_orders_and_reverses() {
+        let object = |key: u8, expiry: i64| {
+            let state = ObjectState {
+                hash: B256([key; 32]),
+                size: 0,
+                expiry,
+                metadata: HashMap::new(),
+            };
+            (vec![key], state)
+        };
+        // Keys 0 and 4 expire at the same epoch, so the key breaks the tie
+        let objects = vec![
+            object(3, 20),
+            object(0, 10),
+            object(4, 10),
+            object(1, 20),
+            object(2, 5),
+        ];
+        for (sort, reverse, keys) in [
+            (QuerySort::None, false, [3, 0, 4, 1, 2]),
+            (QuerySort::None, true, [2, 1, 4, 0, 3]),
+            (QuerySort::Name, false, [0, 1, 2, 3, 4]),
+            (QuerySort::Name, true, [4, 3, 2, 1, 0]),
+            (QuerySort::Expiry, false, [2, 0, 4, 1, 3]),
+            (QuerySort::Expiry, true, [3, 1, 4, 0, 2]),
+        ] {
+            let mut sorted = objects.clone();
+            sort_objects(&mut sorted, sort, reverse);
+            let sorted_keys: Vec<u8> = sorted.iter().map(|(key, _)| key[0]).collect();
+            assert_eq!(sorted_keys, keys, "{:?} reverse={}", sort, reverse);
+        }
+    }
+}
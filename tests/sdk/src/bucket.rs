@@ -10,7 +10,7 @@ mod tests {
 
     use recall_provider::json_rpc::JsonRpcProvider;
     use recall_sdk::machine::{
-        bucket::{AddOptions, Bucket, GetOptions, QueryOptions},
+        bucket::{AddOptions, Bucket, GetOptions, ObjectRange, QueryOptions, QuerySort},
         Machine,
     };
     use recall_signer::{key::parse_secret_key, AccountKind, Wallet};
@@ -97,7 +97,7 @@ mod tests {
         let obj_path = obj_file.file_path().to_owned();
 
         let options = GetOptions {
-            range: Some("0-99".to_string()), // Get the first 100 bytes
+            range: Some(ObjectRange::Bounded { start: 0, end: 99 }), // Get the first 100 bytes
             ..Default::default()
         };
         let open_file = obj_file.open_rw().await.unwrap();
@@ -113,6 +113,20 @@ mod tests {
 
         assert_eq!(contents, &random_data[0..10]);
 
+        // Download the last 10 bytes with a suffix range
+        let obj_file = async_tempfile::TempFile::new().await.unwrap();
+        let options = GetOptions {
+            range: Some(ObjectRange::Suffix(10)),
+            ..Default::default()
+        };
+        let open_file = obj_file.open_rw().await.unwrap();
+        machine
+            .get(&provider, key, open_file, options)
+            .await
+            .unwrap();
+        let contents = tokio::fs::read(obj_file.file_path()).await.unwrap();
+        assert_eq!(contents, &random_data[random_data.len() - 10..]);
+
         // Now, delete the object
         machine
             .delete(&provider, &mut signer, key, Default::default())
@@ -121,4 +135,96 @@ mod tests {
 
         // TODO: failure might throw, but need to add assertion for deleting
     }
+
+    #[tokio::test]
+    #[ignore]
+    async fn can_query_sorted() {
+        let network_config = test_utils::get_network_config();
+        let sk_env = test_utils::get_runner_secret_key();
+        let sk = parse_secret_key(&sk_env).unwrap();
+        let mut signer =
+            Wallet::new_secp256k1(sk, AccountKind::Ethereum, network_config.subnet_id.clone())
+                .unwrap();
+
+        let provider = JsonRpcProvider::new_http(
+            network_config.rpc_url,
+            network_config.subnet_id.chain_id(),
+            None,
+            Some(network_config.object_api_url),
+        )
+        .unwrap();
+        signer.init_sequence(&provider).await.unwrap();
+
+        let (machine, _) = Bucket::new(
+            &provider,
+            &mut signer,
+            None,
+            HashMap::new(),
+            Default::default(),
+        )
+        .await
+        .unwrap();
+
+        let mut file = async_tempfile::TempFile::new().await.unwrap();
+        let mut rng = thread_rng();
+        let mut random_data = vec![0; 1024];
+        rng.fill(&mut random_data[..]);
+        file.write_all(&random_data).await.unwrap();
+        file.flush().await.unwrap();
+
+        // Add the keys out of order, each with a TTL that puts it in yet another order by expiry.
+        // The TTLs are far enough apart that the blocks between the adds don't change that order.
+        let added = [
+            ("3", 12_000),
+            ("1", 14_000),
+            ("4", 10_000),
+            ("0", 11_000),
+            ("2", 13_000),
+        ];
+        for (key, ttl) in added {
+            let options = AddOptions {
+                ttl: Some(ttl),
+                overwrite: true,
+                ..Default::default()
+            };
+            machine
+                .add_from_path(&provider, &mut signer, key, file.file_path(), options)
+                .await
+                .unwrap();
+        }
+
+        let query_keys = |sort: QuerySort, reverse: bool| {
+            let machine = &machine;
+            let provider = &provider;
+            async move {
+                let options = QueryOptions {
+                    sort,
+                    reverse,
+                    ..Default::default()
+                };
+                let list = machine.query(provider, options).await.unwrap();
+                list.objects
+                    .into_iter()
+                    .map(|(key_bytes, _)| String::from_utf8(key_bytes).unwrap())
+                    .collect::<Vec<String>>()
+            }
+        };
+
+        assert_eq!(
+            query_keys(QuerySort::Name, false).await,
+            ["0", "1", "2", "3", "4"]
+        );
+        assert_eq!(
+            query_keys(QuerySort::Name, true).await,
+            ["4", "3", "2", "1", "0"]
+        );
+        assert_eq!(
+            query_keys(QuerySort::Expiry, false).await,
+            ["4", "0", "3", "2", "1"]
+        );
+        assert_eq!(
+            query_keys(QuerySort::Expiry, true).await,
+            ["1", "2", "3", "0", "4"]
+        );
+    }
 }